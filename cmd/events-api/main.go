@@ -4,18 +4,38 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
+	_ "time/tzdata" // IANA zones for /metrics?tz= without OS zoneinfo (alpine)
 
 	"example.com/goAssignment1/internal/config"
 	"example.com/goAssignment1/internal/ingest"
+	"example.com/goAssignment1/internal/storage/memory"
+	"example.com/goAssignment1/internal/storage/ndjson"
 	spg "example.com/goAssignment1/internal/storage/postgres"
+	"example.com/goAssignment1/internal/telemetry"
 	transport "example.com/goAssignment1/internal/transport/http"
+	"example.com/goAssignment1/internal/wal"
+	"example.com/goAssignment1/migrations"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "rollups":
+			runRollups(os.Args[2:])
+			return
+		case "rates":
+			runRates(os.Args[2:])
+			return
+		}
+	}
+
 	cfg := config.Parse()
 	log.Printf("config: DSN=%s port=%s", cfg.PostgresDSN, cfg.Port)
 
@@ -29,22 +49,77 @@ func main() {
 	defer db.Close()
 	log.Printf("db: connected")
 
-	mig := filepath.Join("migrations", "0001_init.sql")
-	if err := db.RunMigration(ctx, mig); err != nil {
-		log.Fatalf("migration: %v", err)
+	if cfg.MigrateOnStart {
+		ms, err := spg.LoadMigrations(migrations.FS)
+		if err != nil {
+			log.Fatalf("migrations: %v", err)
+		}
+		applied, err := db.MigrateUp(ctx, ms)
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+		for _, m := range applied {
+			log.Printf("db: applied migration %04d_%s", m.Version, m.Name)
+		}
+		log.Printf("db: schema up to date (%d applied now, %d known)", len(applied), len(ms))
+	}
+
+	partitions := spg.NewPartitionManager(db, spg.PartitionInterval(cfg.PartitionInterval), cfg.PartitionPremake)
+	go partitions.Run(ctx, cfg.PartitionCheckEvery)
+	log.Printf("db: partition manager started (interval=%s premake=%d)", partitions.Interval(), cfg.PartitionPremake)
+
+	retention := buildRetention(cfg, db)
+	go retention.Run(ctx, cfg.RetentionEvery)
+	log.Printf("db: retention worker started (default=%s rules=%d dry_run=%t)", cfg.RetentionDefault, len(retention.Policy().Rules), cfg.RetentionDryRun)
+
+	wl, err := wal.Open(wal.Options{Dir: cfg.WALDir, SegmentBytes: cfg.WALSegmentBytes, Fsync: cfg.WALFsync})
+	if err != nil {
+		log.Fatalf("wal open: %v", err)
 	}
-	log.Printf("db: migration applied")
+	defer wl.Close()
+	log.Printf("wal: opened dir=%s checkpoint=%d", cfg.WALDir, wl.Checkpoint())
 
-	writer := spg.NewWriter(db)
-	ingestor := ingest.NewIngestor(writer, cfg.QueueMaxSize, cfg.BatchMaxSize, cfg.BatchMaxWait)
-	ingestor.Start(ctx)
-	log.Printf("ingest: started (queue=%d batch=%d wait=%s)", cfg.QueueMaxSize, cfg.BatchMaxSize, cfg.BatchMaxWait)
+	writer := spg.NewWriter(db, spg.InsertMode(cfg.InsertMode))
+	if maxBatch := writer.MaxBatchSize(); maxBatch > 0 && cfg.BatchMaxSize > maxBatch {
+		log.Printf("ingest: BATCH_MAX_SIZE=%d exceeds the %s insert mode limit, using %d (set INSERT_MODE=copy for larger batches)", cfg.BatchMaxSize, writer.Mode(), maxBatch)
+		cfg.BatchMaxSize = maxBatch
+	}
+	if maxBatch := writer.MaxBatchSize(); maxBatch > 0 && cfg.BatchMaxSizeAdaptive > maxBatch {
+		cfg.BatchMaxSizeAdaptive = maxBatch
+	}
+	sinks, closeSinks := buildSinks(cfg, writer)
+	defer closeSinks()
+
+	receipts := ingest.NewReceiptStore(cfg.ReceiptTTL, cfg.ReceiptMaxEntries)
+	ingestor := ingest.NewIngestor(ingest.Options{
+		Sinks:        sinks,
+		WAL:          wl,
+		Receipts:     receipts,
+		QueueMaxSize: cfg.QueueMaxSize,
+		BatchMaxSize: cfg.BatchMaxSize,
+		BatchMaxWait: cfg.BatchMaxWait,
+		Batching: ingest.BatchBounds{
+			Adaptive:      cfg.BatchAdaptive,
+			MinSize:       cfg.BatchMinSizeAdaptive,
+			MaxSize:       max(cfg.BatchMaxSizeAdaptive, cfg.BatchMinSizeAdaptive),
+			MinWait:       cfg.BatchMinWaitAdaptive,
+			MaxWait:       max(cfg.BatchMaxWaitAdaptive, cfg.BatchMinWaitAdaptive),
+			TargetLatency: cfg.BatchTargetLatency,
+		},
+		Retry:   ingest.RetryPolicy{MaxAttempts: cfg.RetryMaxAttempts, BaseDelay: cfg.RetryBaseDelay, MaxDelay: cfg.RetryMaxDelay},
+		Workers: cfg.IngestWorkers,
+	})
+	ingestor.Start()
+	log.Printf("ingest: started (queue=%d batch=%d wait=%s adaptive=%t mode=%s workers=%d)", cfg.QueueMaxSize, cfg.BatchMaxSize, cfg.BatchMaxWait, cfg.BatchAdaptive, writer.Mode(), cfg.IngestWorkers)
 
 	deps := &transport.ServerDeps{
-		Cfg:      cfg,
-		Ingestor: ingestor,
-		DB:       db,
-		Now:      func() time.Time { return time.Now().UTC() },
+		Cfg:       cfg,
+		Ingestor:  ingestor,
+		DB:        db,
+		Retention: retention,
+		Now:       func() time.Time { return time.Now().UTC() },
+
+		CohortCache: transport.NewResultCache[[]spg.Cohort](cfg.CohortCacheTTL, cfg.CohortCacheMaxEntries),
 	}
 	h := deps.Router()
 
@@ -64,8 +139,94 @@ func main() {
 		}
 	}()
 
+	// Prometheus exposition lives on its own port: /metrics on the API port is
+	// the business metrics endpoint.
+	promMux := http.NewServeMux()
+	promMux.Handle("/metrics", telemetry.Default.Handler())
+	promSrv := &http.Server{
+		Addr:              ":" + cfg.MetricsPort,
+		Handler:           promMux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		log.Printf("prometheus metrics on :%s/metrics", cfg.MetricsPort)
+		if err := promSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("prometheus server: %v", err)
+		}
+	}()
+
 	<-ctx.Done()
-	shutdownCtx, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+	log.Printf("shutdown: signal received, stopping HTTP server")
+
+	// 1) stop accepting traffic and let in-flight requests finish
+	shutdownCtx, cancel2 := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel2()
-	_ = srv.Shutdown(shutdownCtx)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: http server: %v", err)
+	}
+
+	// 2) drain the ingest queue on a fresh, deadline-bound context
+	drainCtx, cancel3 := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel3()
+	rep, err := ingestor.Stop(drainCtx)
+	if err != nil {
+		log.Printf("shutdown: ingest drain incomplete: %v", err)
+	}
+	log.Printf("shutdown: ingest flushed=%d abandoned=%d (abandoned events stay in the WAL and are replayed on next start)", rep.Flushed, rep.Abandoned)
+
+	// keep the scrape endpoint up until the drain is done
+	promCtx, cancel4 := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel4()
+	_ = promSrv.Shutdown(promCtx)
+}
+
+// buildSinks resolves SINKS / SINKS_BEST_EFFORT into ingest sinks.
+func buildSinks(cfg config.Config, writer *spg.Writer) ([]ingest.SinkSpec, func()) {
+	var (
+		specs   []ingest.SinkSpec
+		closers []func() error
+	)
+	for _, name := range cfg.Sinks {
+		var s ingest.Sink
+		switch name {
+		case "postgres":
+			s = writer
+		case "ndjson":
+			nd, err := ndjson.Open(cfg.NDJSONDir, cfg.NDJSONMaxBytes)
+			if err != nil {
+				log.Fatalf("ndjson sink: %v", err)
+			}
+			closers = append(closers, nd.Close)
+			s = nd
+		case "memory":
+			s = memory.NewSink()
+		default:
+			log.Fatalf("unknown sink %q (want postgres, ndjson or memory)", name)
+		}
+		_, bestEffort := cfg.SinksBestEffort[name]
+		specs = append(specs, ingest.SinkSpec{Sink: s, BestEffort: bestEffort})
+		log.Printf("ingest: sink %s (best_effort=%t)", name, bestEffort)
+	}
+	if len(specs) == 0 {
+		log.Fatalf("no sinks configured (SINKS)")
+	}
+	return specs, func() {
+		for _, c := range closers {
+			_ = c()
+		}
+	}
+}
+
+// buildRetention resolves RETENTION_DEFAULT / RETENTION_RULES into a worker.
+func buildRetention(cfg config.Config, db *spg.DB) *spg.RetentionWorker {
+	days, err := spg.ParseRetentionTTL(cfg.RetentionDefault)
+	if err != nil {
+		log.Fatalf("RETENTION_DEFAULT: %v", err)
+	}
+	rules, err := spg.ParseRetentionRules(cfg.RetentionRules)
+	if err != nil {
+		log.Fatalf("RETENTION_RULES: %v", err)
+	}
+	policy := spg.RetentionPolicy{DefaultTTLDays: days, Rules: rules}
+	return spg.NewRetentionWorker(db, policy, cfg.RetentionChunkSize, cfg.RetentionDryRun)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"example.com/goAssignment1/internal/config"
+	spg "example.com/goAssignment1/internal/storage/postgres"
+	"example.com/goAssignment1/migrations"
+)
+
+const migrateUsage = `usage: events-api migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [N]    revert the last N applied migrations (default 1)
+  status      list migrations and whether they are applied`
+
+// runMigrate implements `events-api migrate up|down|status`.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	cfg := config.Parse()
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	ms, err := spg.LoadMigrations(migrations.FS)
+	if err != nil {
+		log.Fatalf("migrations: %v", err)
+	}
+	db, err := spg.Connect(ctx, cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("db connect: %v", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx, ms)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("nothing to apply")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("migrate down: invalid step count %q", args[1])
+			}
+		}
+		reverted, err := db.MigrateDown(ctx, ms, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate down: %v", err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("nothing to revert")
+		}
+	case "status":
+		states, err := db.MigrationStatus(ctx, ms)
+		if err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+		for _, st := range states {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.UTC().Format("2006-01-02T15:04:05Z")
+			}
+			if st.ChecksumMismatch {
+				state += " (CHECKSUM MISMATCH)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", st.Version, st.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
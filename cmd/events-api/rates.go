@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"example.com/goAssignment1/internal/config"
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+const ratesUsage = `usage: events-api rates import -file rates.csv
+
+Loads daily exchange rates from a CSV file with the columns
+date (YYYY-MM-DD), currency (ISO 4217) and usd_per_unit; a header row is
+optional. Existing rates for the same currency and day are replaced.`
+
+// ratesBatch is how many rates are written per statement.
+const ratesBatch = 5000
+
+// runRates implements `events-api rates import`.
+func runRates(args []string) {
+	if len(args) == 0 || args[0] != "import" {
+		fmt.Fprintln(os.Stderr, ratesUsage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("rates import", flag.ExitOnError)
+	file := fs.String("file", "", "CSV file to import (- for stdin)")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, ratesUsage) }
+	_ = fs.Parse(args[1:])
+	if *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("rates import: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	rates, err := readRatesCSV(in)
+	if err != nil {
+		log.Fatalf("rates import: %v", err)
+	}
+
+	cfg := config.Parse()
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := spg.Connect(ctx, cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("db connect: %v", err)
+	}
+	defer db.Close()
+
+	var total int64
+	for len(rates) > 0 {
+		n := min(len(rates), ratesBatch)
+		written, err := db.UpsertExchangeRates(ctx, rates[:n])
+		if err != nil {
+			log.Fatalf("rates import: %v (after %d rates)", err, total)
+		}
+		total += written
+		rates = rates[n:]
+	}
+	fmt.Printf("imported %d rates\n", total)
+}
+
+// readRatesCSV parses and validates every row before anything is written.
+func readRatesCSV(r io.Reader) ([]spg.ExchangeRate, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	var out []spg.ExchangeRate
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && rec[0] == "date" {
+			continue
+		}
+		v, err := strconv.ParseFloat(rec[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: usd_per_unit %q is not a number", line, rec[2])
+		}
+		rate := spg.ExchangeRate{Day: rec[0], Currency: rec[1], USDPerUnit: v}
+		if err := rate.Normalize(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		out = append(out, rate)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no rates in input")
+	}
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"example.com/goAssignment1/internal/config"
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+const rollupsUsage = `usage: events-api rollups backfill [-from YYYY-MM-DD] [-to YYYY-MM-DD]
+
+Recomputes the hourly and daily rollups for the UTC days in [from, to) from
+the events table. Defaults: from = 30 days ago, to = tomorrow.`
+
+// runRollups implements `events-api rollups backfill`.
+func runRollups(args []string) {
+	if len(args) == 0 || args[0] != "backfill" {
+		fmt.Fprintln(os.Stderr, rollupsUsage)
+		os.Exit(2)
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	fs := flag.NewFlagSet("rollups backfill", flag.ExitOnError)
+	fromStr := fs.String("from", today.AddDate(0, 0, -30).Format(time.DateOnly), "first UTC day to rebuild")
+	toStr := fs.String("to", today.AddDate(0, 0, 1).Format(time.DateOnly), "UTC day to stop before")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, rollupsUsage) }
+	_ = fs.Parse(args[1:])
+
+	from, err := time.Parse(time.DateOnly, *fromStr)
+	if err != nil {
+		log.Fatalf("rollups backfill: invalid -from %q", *fromStr)
+	}
+	to, err := time.Parse(time.DateOnly, *toStr)
+	if err != nil || !to.After(from) {
+		log.Fatalf("rollups backfill: invalid -to %q (must be a day after -from)", *toStr)
+	}
+
+	cfg := config.Parse()
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := spg.Connect(ctx, cfg.PostgresDSN)
+	if err != nil {
+		log.Fatalf("db connect: %v", err)
+	}
+	defer db.Close()
+
+	days, err := db.RebuildRollups(ctx, from.Unix(), to.Unix(), func(day int64) {
+		fmt.Printf("rebuilt %s\n", time.Unix(day, 0).UTC().Format(time.DateOnly))
+	})
+	if err != nil {
+		log.Fatalf("rollups backfill: %v (after %d days)", err, days)
+	}
+	fmt.Printf("rebuilt %d days\n", days)
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// DeadLetter is an event that permanently failed to insert, parked with the
+// error that rejected it.
+type DeadLetter struct {
+	ID        int64     `json:"id"`
+	Event     Event     `json:"event"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,191 @@
+// Package hll implements HyperLogLog sketches for approximate distinct counts.
+//
+// Sketches with the same precision merge losslessly (register-wise max), so
+// unique counts can be combined across buckets and dimensions, which exact
+// COUNT(DISTINCT) results cannot.
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision is the default number of index bits: 4096 registers, a 4 KiB
+// dense sketch and about 1.6% relative standard error.
+const Precision = 12
+
+const (
+	minPrecision = 4
+	maxPrecision = 16
+
+	version     = 1
+	encDense    = 0
+	encSparse   = 1
+	headerBytes = 3 // version, precision, encoding
+)
+
+var ErrPrecisionMismatch = errors.New("hll: sketches have different precision")
+
+// Sketch is a HyperLogLog sketch. The zero value is not usable; call New.
+type Sketch struct {
+	p    uint8
+	regs []uint8
+}
+
+// New returns an empty sketch with 2^p registers (p in [4, 16]).
+func New(p uint8) *Sketch {
+	if p < minPrecision || p > maxPrecision {
+		panic(fmt.Sprintf("hll: precision %d out of range [%d, %d]", p, minPrecision, maxPrecision))
+	}
+	return &Sketch{p: p, regs: make([]uint8, 1<<p)}
+}
+
+// RelativeError is the standard error of Estimate for precision p: 1.04/sqrt(2^p).
+func RelativeError(p uint8) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<p))
+}
+
+func (s *Sketch) Precision() uint8 { return s.p }
+
+// AddString adds one element.
+func (s *Sketch) AddString(v string) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(v))
+	s.addHash(mix64(h.Sum64()))
+}
+
+func (s *Sketch) addHash(x uint64) {
+	idx := x >> (64 - s.p)
+	// the guard bit caps rho at 64-p+1 when the remaining bits are all zero
+	w := x<<s.p | 1<<(s.p-1)
+	rho := uint8(bits.LeadingZeros64(w) + 1)
+	if rho > s.regs[idx] {
+		s.regs[idx] = rho
+	}
+}
+
+// Merge folds other into s.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.p != s.p {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.regs {
+		if r > s.regs[i] {
+			s.regs[i] = r
+		}
+	}
+	return nil
+}
+
+// Estimate returns the approximate number of distinct elements added.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.regs))
+	var sum float64
+	zeros := 0
+	for _, r := range s.regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := alpha(len(s.regs)) * m * m / sum
+	// small-range correction (linear counting); a 64-bit hash needs no
+	// large-range correction
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// MarshalBinary encodes the sketch, using a sparse (index, value) list while
+// few registers are set, so sketches of small buckets stay small.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	set := 0
+	for _, r := range s.regs {
+		if r != 0 {
+			set++
+		}
+	}
+	if 3*set+2 >= len(s.regs) {
+		out := make([]byte, headerBytes, headerBytes+len(s.regs))
+		out[0], out[1], out[2] = version, s.p, encDense
+		return append(out, s.regs...), nil
+	}
+	out := make([]byte, headerBytes+2, headerBytes+2+3*set)
+	out[0], out[1], out[2] = version, s.p, encSparse
+	binary.BigEndian.PutUint16(out[3:], uint16(set))
+	for i, r := range s.regs {
+		if r != 0 {
+			out = binary.BigEndian.AppendUint16(out, uint16(i))
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// UnmarshalBinary decodes a sketch written by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(b []byte) error {
+	if len(b) < headerBytes || b[0] != version {
+		return errors.New("hll: unknown encoding")
+	}
+	p := b[1]
+	if p < minPrecision || p > maxPrecision {
+		return fmt.Errorf("hll: precision %d out of range", p)
+	}
+	regs := make([]uint8, 1<<p)
+	body := b[headerBytes:]
+	switch b[2] {
+	case encDense:
+		if len(body) != len(regs) {
+			return errors.New("hll: truncated dense sketch")
+		}
+		copy(regs, body)
+	case encSparse:
+		if len(body) < 2 {
+			return errors.New("hll: truncated sparse sketch")
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		body = body[2:]
+		if len(body) != 3*n {
+			return errors.New("hll: truncated sparse sketch")
+		}
+		for i := 0; i < n; i++ {
+			idx := int(binary.BigEndian.Uint16(body[3*i:]))
+			if idx >= len(regs) {
+				return errors.New("hll: register index out of range")
+			}
+			regs[idx] = body[3*i+2]
+		}
+	default:
+		return errors.New("hll: unknown encoding")
+	}
+	s.p, s.regs = p, regs
+	return nil
+}
+
+// mix64 is the MurmurHash3 finalizer; FNV alone leaves the high bits, which
+// pick the register, poorly distributed for short, similar keys.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,208 @@
+package hll
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func sketchOf(p uint8, from, to int) *Sketch {
+	s := New(p)
+	for i := from; i < to; i++ {
+		s.AddString(fmt.Sprintf("user-%d", i))
+	}
+	return s
+}
+
+func TestEstimateError(t *testing.T) {
+	// three standard errors; small cardinalities are exact via linear counting
+	bound := 3 * RelativeError(Precision)
+	for _, n := range []int{0, 1, 10, 100, 1000, 5000, 10_000, 100_000, 1_000_000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			if n >= 1_000_000 && testing.Short() {
+				t.Skip("large cardinality")
+			}
+			est := sketchOf(Precision, 0, n).Estimate()
+			if n <= 10 {
+				if est != uint64(n) {
+					t.Fatalf("estimate = %d, want exactly %d", est, n)
+				}
+				return
+			}
+			if rel := math.Abs(float64(est)-float64(n)) / float64(n); rel > bound {
+				t.Fatalf("estimate = %d for %d elements: relative error %.4f > %.4f", est, n, rel, bound)
+			}
+		})
+	}
+}
+
+func TestDuplicatesDoNotCount(t *testing.T) {
+	s := New(Precision)
+	for range 3 {
+		for i := range 500 {
+			s.AddString(fmt.Sprintf("user-%d", i))
+		}
+	}
+	if got, want := s.Estimate(), sketchOf(Precision, 0, 500).Estimate(); got != want {
+		t.Fatalf("estimate with duplicates = %d, want %d", got, want)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		p uint8
+		n int
+	}{
+		{Precision, 0},
+		{Precision, 1},
+		{Precision, 1000},
+		{Precision, 100_000},
+		{4, 3},
+		{maxPrecision, 50_000},
+	} {
+		t.Run(fmt.Sprintf("p=%d/n=%d", tc.p, tc.n), func(t *testing.T) {
+			s := sketchOf(tc.p, 0, tc.n)
+			b, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Sketch
+			if err := got.UnmarshalBinary(b); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got.Precision() != tc.p || !bytes.Equal(got.regs, s.regs) {
+				t.Fatal("registers differ after round trip")
+			}
+			if got.Estimate() != s.Estimate() {
+				t.Fatalf("estimate %d, want %d", got.Estimate(), s.Estimate())
+			}
+		})
+	}
+}
+
+func TestSparseToDenseTransition(t *testing.T) {
+	s := New(Precision)
+	m := 1 << Precision
+	wasSparse := false
+	for i := 0; ; i++ {
+		s.AddString(fmt.Sprintf("user-%d", i))
+		set := 0
+		for _, r := range s.regs {
+			if r != 0 {
+				set++
+			}
+		}
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if 3*set+2 < m {
+			if b[2] != encSparse || len(b) != headerBytes+2+3*set {
+				t.Fatalf("%d registers set: encoding %d, %d bytes; want sparse", set, b[2], len(b))
+			}
+			wasSparse = true
+			continue
+		}
+		// the sparse form would no longer be smaller than the dense one
+		if !wasSparse || b[2] != encDense || len(b) != headerBytes+m {
+			t.Fatalf("%d registers set: encoding %d, %d bytes; want dense", set, b[2], len(b))
+		}
+		var got Sketch
+		if err := got.UnmarshalBinary(b); err != nil || !bytes.Equal(got.regs, s.regs) {
+			t.Fatalf("dense round trip failed: %v", err)
+		}
+		return
+	}
+}
+
+// The users_hll rollup column stores this encoding; it must not change
+// without a migration.
+func TestEncodingIsStable(t *testing.T) {
+	for _, tc := range []struct {
+		p     uint8
+		users []string
+		want  string
+	}{
+		{Precision, nil, "010c010000"},
+		{Precision, []string{"u1", "u2", "u3"}, "010c010003063b010807040c4002"},
+		{4, []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}, "01040000010001040001020300020003010000"},
+	} {
+		s := New(tc.p)
+		for _, u := range tc.users {
+			s.AddString(u)
+		}
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(b); got != tc.want {
+			t.Errorf("p=%d users=%v: encoding %s, want %s", tc.p, tc.users, got, tc.want)
+		}
+	}
+}
+
+func TestUnmarshalRejectsBadInput(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hex  string
+	}{
+		{"empty", ""},
+		{"short header", "010c"},
+		{"unknown version", "020c010000"},
+		{"precision too small", "0103010000"},
+		{"precision too large", "0111010000"},
+		{"unknown encoding", "010c020000"},
+		{"truncated dense", "01040000"},
+		{"sparse without count", "010c01"},
+		{"truncated sparse", "010c010002063b01"},
+		{"sparse index out of range", "010401000100ff01"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _ := hex.DecodeString(tc.hex)
+			var s Sketch
+			if err := s.UnmarshalBinary(b); err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := sketchOf(Precision, 0, 3000)
+	b := sketchOf(Precision, 2000, 6000)
+	c := sketchOf(Precision, 5000, 9000)
+	clone := func(s *Sketch) *Sketch {
+		out := New(s.p)
+		copy(out.regs, s.regs)
+		return out
+	}
+	merge := func(x, y *Sketch) *Sketch {
+		out := clone(x)
+		if err := out.Merge(y); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	left := merge(merge(a, b), c)
+	right := merge(a, merge(b, c))
+	if !bytes.Equal(left.regs, right.regs) {
+		t.Fatal("merge is not associative")
+	}
+	if !bytes.Equal(merge(a, b).regs, merge(b, a).regs) {
+		t.Fatal("merge is not commutative")
+	}
+	if !bytes.Equal(merge(a, a).regs, a.regs) {
+		t.Fatal("merge is not idempotent")
+	}
+	// merging is lossless: same registers as one sketch over the union
+	if union := sketchOf(Precision, 0, 9000); !bytes.Equal(left.regs, union.regs) {
+		t.Fatal("merged sketch differs from the sketch of the union")
+	}
+
+	if err := New(Precision).Merge(New(10)); err != ErrPrecisionMismatch {
+		t.Fatalf("merge across precisions: err = %v, want ErrPrecisionMismatch", err)
+	}
+}
@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/goAssignment1/internal/domain"
+)
+
+// DeadLetterStore holds parked events so they can be redriven.
+type DeadLetterStore interface {
+	// TakeDeadLetters removes the given entries and returns those that
+	// existed; the removal is durable by the time it returns.
+	TakeDeadLetters(ctx context.Context, ids []int64) ([]domain.DeadLetter, error)
+	// RestoreDeadLetters parks taken entries again under their original ids.
+	RestoreDeadLetters(ctx context.Context, dls []domain.DeadLetter) error
+}
+
+// RedriveDeadLetters re-submits parked events. They are taken out of the
+// store first and only enqueued once that has committed; if enqueueing fails
+// they are parked again, so an event is never both re-ingested and still
+// parked. It returns the ticket of the re-submitted events (nil when none of
+// ids was parked) and how many there were. Enqueue errors are wrapped, so
+// ErrQueueFull and ErrStopped can be told apart with errors.Is.
+func (ig *Ingestor) RedriveDeadLetters(ctx context.Context, store DeadLetterStore, ids []int64) (*Ticket, int, error) {
+	dls, err := store.TakeDeadLetters(ctx, ids)
+	if err != nil || len(dls) == 0 {
+		return nil, 0, err
+	}
+	evs := make([]domain.Event, len(dls))
+	for i := range dls {
+		evs[i] = dls[i].Event
+	}
+	t, err := ig.EnqueueMany(evs)
+	if err != nil {
+		// the caller may be gone by now; the entries must not be
+		if rerr := store.RestoreDeadLetters(context.WithoutCancel(ctx), dls); rerr != nil {
+			return nil, 0, fmt.Errorf("redrive: %w (re-parking %d dead letters also failed: %v)", err, len(dls), rerr)
+		}
+		return nil, 0, fmt.Errorf("redrive: %w", err)
+	}
+	return t, len(dls), nil
+}
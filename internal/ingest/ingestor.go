@@ -2,82 +2,429 @@ package ingest
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"example.com/goAssignment1/internal/domain"
-	spg "example.com/goAssignment1/internal/storage/postgres"
+	"example.com/goAssignment1/internal/wal"
 )
 
+var (
+	// ErrQueueFull is returned by Enqueue when the in-memory queue has no room.
+	ErrQueueFull = errors.New("ingest queue is full")
+	// ErrStopped is returned by Enqueue once Stop has been called.
+	ErrStopped = errors.New("ingestor is shutting down")
+)
+
+// record is a queued event together with its WAL sequence, its receipt and,
+// for events submitted in this process, the ticket waiting for its outcome.
+type record struct {
+	seq      uint64
+	ev       domain.Event
+	receipt  string
+	idx      int // position within the receipt/ticket
+	ticket   *Ticket
+	redrives int // in-process re-drives after transient failures
+}
+
+// walEntry is the WAL payload of one event.
+type walEntry struct {
+	Receipt string       `json:"receipt,omitempty"`
+	Index   int          `json:"index"`
+	Event   domain.Event `json:"event"`
+}
+
+// Ingestor accepts events into a durable WAL and batches them into sinks.
+// An event is only acknowledged in the WAL after the batch containing it has
+// been committed by every required sink. Events that failed transiently are
+// re-driven in-process with backoff; anything still unacknowledged at Stop is
+// replayed on the next Start.
 type Ingestor struct {
-	queue        chan domain.Event
-	writer       *spg.Writer
-	batchMaxSize int
-	batchMaxWait time.Duration
+	mu           sync.Mutex // serializes WAL append + queue send so capacity checks hold
+	queue        chan record
+	wal          *wal.Log
+	sinks        []SinkSpec
+	tuner        *tuner
+	retry        RetryPolicy
+	receipts     *ReceiptStore
+	redrives     redriveQueue
+	workers      []*worker
+	wg           sync.WaitGroup
+	stopped      bool // guarded by mu; set by Stop
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+	writeCtx     context.Context // outlives the caller's ctx; cancelled at the Stop deadline
+	cancelWrites context.CancelFunc
+	committed    atomic.Int64 // events acknowledged in the WAL
+}
+
+// StopReport summarizes a shutdown drain.
+type StopReport struct {
+	Flushed   int64  // events committed after Stop was called
+	Abandoned uint64 // events left unacknowledged in the WAL (replayed on next start)
+}
+
+// RetryPolicy controls how transient insert failures are retried.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts per (sub-)batch, including the first
+	BaseDelay   time.Duration // backoff before the 2nd attempt
+	MaxDelay    time.Duration // backoff cap
+}
+
+// Options configures an Ingestor.
+type Options struct {
+	Sinks        []SinkSpec
+	WAL          *wal.Log
+	Receipts     *ReceiptStore
+	QueueMaxSize int
+	BatchMaxSize int
+	BatchMaxWait time.Duration
+	Batching     BatchBounds // optional adaptive tuning of size/wait
+	Retry        RetryPolicy
+	Workers      int // concurrent batch writers (default 1)
 }
 
-func NewIngestor(writer *spg.Writer, queueMaxSize, batchMaxSize int, batchMaxWait time.Duration) *Ingestor {
-	return &Ingestor{
-		queue:        make(chan domain.Event, queueMaxSize),
-		writer:       writer,
-		batchMaxSize: batchMaxSize,
-		batchMaxWait: batchMaxWait,
+func NewIngestor(opts Options) *Ingestor {
+	if opts.Retry.MaxAttempts < 1 {
+		opts.Retry.MaxAttempts = 1
 	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	workers := make([]*worker, opts.Workers)
+	for i := range workers {
+		workers[i] = &worker{id: i}
+	}
+	writeCtx, cancelWrites := context.WithCancel(context.Background())
+	ig := &Ingestor{
+		stopCh:       make(chan struct{}),
+		writeCtx:     writeCtx,
+		cancelWrites: cancelWrites,
+		queue:        make(chan record, opts.QueueMaxSize),
+		wal:          opts.WAL,
+		sinks:        opts.Sinks,
+		tuner:        newTuner(opts.Batching, opts.BatchMaxSize, opts.BatchMaxWait),
+		retry:        opts.Retry,
+		receipts:     opts.Receipts,
+		workers:      workers,
+	}
+	ig.registerGauges()
+	return ig
 }
 
-func (ig *Ingestor) Start(ctx context.Context) {
+// Receipts exposes receipt lookups (GET /ingest/receipts/{id}).
+func (ig *Ingestor) Receipts() *ReceiptStore { return ig.receipts }
+
+// Start launches the batcher and the writer workers.
+//
+// The batcher replays unacknowledged WAL records, then cuts batches from the
+// queue (by size or wait time) and hands them to Workers writer goroutines.
+// Ordering: events keep their relative order inside a batch, but batches are
+// written concurrently and may commit out of order; the WAL checkpoint only
+// advances over the contiguous committed prefix. With Workers=1 batches
+// commit strictly in order.
+//
+// Writes run on the ingestor's own context, not the caller's, so that a
+// shutdown signal does not abort the final drain; see Stop.
+func (ig *Ingestor) Start() {
+	batches := make(chan batchJob, len(ig.workers))
+	for _, wk := range ig.workers {
+		ig.wg.Add(1)
+		go func() {
+			defer ig.wg.Done()
+			for job := range batches {
+				ig.runBatch(ig.writeCtx, wk, job)
+			}
+		}()
+	}
+	ig.wg.Add(1)
 	go func() {
-		batch := make([]domain.Event, 0, ig.batchMaxSize)
-		t := time.NewTimer(ig.batchMaxWait)
-		defer t.Stop()
+		defer ig.wg.Done()
+		defer close(batches)
+		ig.runBatcher(batches)
+	}()
+}
 
-		resetTimer := func() {
-			if !t.Stop() {
-				select {
-				case <-t.C:
-				default:
-				}
+// Stop rejects new events, flushes everything still queued and blocks until
+// all in-flight batches are done or ctx ends. When ctx ends first, pending
+// writes are cancelled; whatever was not committed stays in the WAL and is
+// replayed on the next start.
+func (ig *Ingestor) Stop(ctx context.Context) (StopReport, error) {
+	before := ig.committed.Load()
+	ig.stopOnce.Do(func() {
+		ig.mu.Lock()
+		ig.stopped = true
+		ig.mu.Unlock()
+		close(ig.stopCh)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ig.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		ig.cancelWrites()
+		<-done
+	}
+	ig.cancelWrites()
+	return StopReport{
+		Flushed:   ig.committed.Load() - before,
+		Abandoned: ig.wal.Pending(),
+	}, err
+}
+
+// batchJob is a cut batch plus the size limit it was cut against.
+type batchJob struct {
+	recs   []record
+	target int
+}
+
+func (ig *Ingestor) runBatcher(out chan<- batchJob) {
+	size, wait := ig.tuner.current()
+	batch := make([]record, 0, size)
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	resetTimer := func() {
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
 			}
-			t.Reset(ig.batchMaxWait)
 		}
+		t.Reset(wait)
+	}
 
-		flush := func() {
-			if len(batch) == 0 {
-				resetTimer()
-				return
-			}
-			affected, err := ig.writer.InsertBatch(ctx, batch)
-			if err != nil {
-				log.Printf("[ingest] batch insert FAILED: err=%v dropped=%d", err, len(batch))
-			} else {
-				log.Printf("[ingest] batch insert OK: inserted=%d size=%d", affected, len(batch))
+	flush := func() {
+		if len(batch) > 0 {
+			mBatchSize.Observe(float64(len(batch)))
+			out <- batchJob{recs: batch, target: size}
+		}
+		size, wait = ig.tuner.current()
+		if len(batch) > 0 {
+			batch = make([]record, 0, size)
+		}
+		resetTimer()
+	}
+
+	redrive := time.NewTicker(redriveTick)
+	defer redrive.Stop()
+
+	replayed := 0
+	err := ig.wal.Replay(func(seq uint64, payload []byte) error {
+		rec, err := decodeWALEntry(seq, payload)
+		if err != nil {
+			// the event was accepted with a 202: keep a copy before letting
+			// go of it, and stop replaying if even that is impossible
+			if qerr := ig.wal.Quarantine(seq, payload); qerr != nil {
+				return fmt.Errorf("undecodable record seq=%d (%v) not quarantined: %w", seq, err, qerr)
 			}
-			batch = batch[:0]
-			resetTimer()
+			mQuarantined.Inc()
+			log.Printf("[ingest] wal replay: quarantined undecodable record seq=%d: %v", seq, err)
+			return ig.wal.Ack(seq)
+		}
+		if rec.receipt != "" {
+			ig.receipts.restore(rec.receipt, rec.idx, rec.ev)
+		}
+		batch = append(batch, rec)
+		replayed++
+		if len(batch) >= size {
+			flush()
 		}
+		select {
+		case <-ig.stopCh:
+			return ErrStopped
+		default:
+			return nil
+		}
+	})
+	flush()
+	if err != nil {
+		log.Printf("[ingest] wal replay stopped: %v", err)
+	} else if replayed > 0 {
+		log.Printf("[ingest] wal replay: re-submitted %d unacknowledged events", replayed)
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				flush()
-				return
-			case ev := <-ig.queue:
-				batch = append(batch, ev)
-				if len(batch) >= ig.batchMaxSize {
+	for {
+		select {
+		case <-ig.stopCh:
+			// Enqueue is closed, so the queue only shrinks from here on.
+			for {
+				select {
+				case rec := <-ig.queue:
+					batch = append(batch, rec)
+					if len(batch) >= size {
+						flush()
+					}
+				default:
 					flush()
+					return
 				}
-			case <-t.C:
+			}
+		case rec := <-ig.queue:
+			batch = append(batch, rec)
+			if len(batch) >= size {
 				flush()
 			}
+		case <-t.C:
+			flush()
+		case now := <-redrive.C:
+			for _, rec := range ig.redrives.takeDue(now) {
+				batch = append(batch, rec)
+				if len(batch) >= size {
+					flush()
+				}
+			}
 		}
-	}()
+	}
 }
 
-func (ig *Ingestor) Enqueue(ev domain.Event) bool {
-	select {
-	case ig.queue <- ev:
-		return true
-	default:
-		return false
+// writeBatch delivers one batch to every sink (concurrently, each with its own
+// retries), resolves outcomes and acknowledges in the WAL whatever every
+// required sink has handled. Events a required sink could not store stay
+// unacknowledged and are re-driven after a backoff.
+func (ig *Ingestor) writeBatch(ctx context.Context, batch []record) (failed int) {
+	results := make([][]Outcome, len(ig.sinks))
+	var wg sync.WaitGroup
+	for i, spec := range ig.sinks {
+		results[i] = make([]Outcome, len(batch))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ig.deliver(ctx, spec.Sink, batch, results[i])
+		}()
+	}
+	wg.Wait()
+
+	seqs := make([]uint64, 0, len(batch))
+	for j, rec := range batch {
+		var out *Outcome
+		for i, spec := range ig.sinks {
+			if spec.BestEffort {
+				continue
+			}
+			o := results[i][j]
+			if out == nil || o.Status == StatusFailed {
+				out = &o
+			}
+		}
+		if out == nil {
+			// only best-effort sinks are configured; nothing gates the ack
+			out = &Outcome{Status: StatusInserted}
+		}
+		ig.resolve(rec, *out)
+		if out.Status == StatusFailed {
+			failed++
+			ig.redrive(rec)
+			continue
+		}
+		seqs = append(seqs, rec.seq)
+	}
+	if failed > 0 {
+		log.Printf("[ingest] %d/%d events not stored by all required sinks (kept in wal, re-driving; %d waiting)", failed, len(batch), ig.redrives.len())
+	}
+	ig.ack(seqs)
+	return failed
+}
+
+// backoff returns a jittered delay in [d/2, d] where d = base * 2^(attempt-1), capped.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func (ig *Ingestor) resolve(rec record, o Outcome) {
+	mOutcomes.With(string(o.Status)).Inc()
+	if rec.ticket != nil {
+		rec.ticket.resolve(rec.idx, o)
+	}
+	if rec.receipt != "" {
+		ig.receipts.resolve(rec.receipt, rec.idx, o)
+	}
+}
+
+func (ig *Ingestor) ack(seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+	ig.committed.Add(int64(len(seqs)))
+	if err := ig.wal.Ack(seqs...); err != nil {
+		log.Printf("[ingest] wal checkpoint FAILED: %v", err)
+	}
+}
+
+// Enqueue durably logs a single event and queues it for batching.
+func (ig *Ingestor) Enqueue(ev domain.Event) (*Ticket, error) {
+	return ig.EnqueueMany([]domain.Event{ev})
+}
+
+// EnqueueMany durably logs events and queues them for batching. It is
+// all-or-nothing: either every event is written to the WAL and queued, or
+// none is (ErrQueueFull / WAL error). The returned ticket carries the receipt
+// id and can be waited on for per-event outcomes; async callers may ignore it.
+func (ig *Ingestor) EnqueueMany(evs []domain.Event) (*Ticket, error) {
+	receipt := newReceiptID()
+	payloads := make([][]byte, len(evs))
+	for i := range evs {
+		b, err := json.Marshal(walEntry{Receipt: receipt, Index: i, Event: evs[i]})
+		if err != nil {
+			return nil, fmt.Errorf("encode event: %w", err)
+		}
+		payloads[i] = b
+	}
+
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	if ig.stopped {
+		mRejected.With("stopped").Add(float64(len(evs)))
+		return nil, ErrStopped
+	}
+	if len(ig.queue)+len(evs) > cap(ig.queue) {
+		mRejected.With("queue_full").Add(float64(len(evs)))
+		return nil, ErrQueueFull
+	}
+	seqs, err := ig.wal.AppendBatch(payloads)
+	if err != nil {
+		mRejected.With("wal_error").Add(float64(len(evs)))
+		return nil, err
+	}
+	mEnqueued.Add(float64(len(evs)))
+	ig.receipts.create(receipt, evs)
+	t := newTicket(receipt, len(evs))
+	for i := range evs {
+		ig.queue <- record{seq: seqs[i], ev: evs[i], receipt: receipt, idx: i, ticket: t}
+	}
+	return t, nil
+}
+
+// decodeWALEntry decodes a payload written by EnqueueMany. Fields it does not
+// know are ignored, so entries written by a newer version still replay; an
+// entry without an event is rejected rather than guessed at.
+func decodeWALEntry(seq uint64, payload []byte) (record, error) {
+	var e walEntry
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return record{}, fmt.Errorf("decode wal entry: %w", err)
+	}
+	if e.Event.EventName == "" || e.Event.UserID == "" || e.Index < 0 {
+		return record{}, errors.New("decode wal entry: not an event entry")
 	}
+	return record{seq: seq, ev: e.Event, receipt: e.Receipt, idx: e.Index}, nil
 }
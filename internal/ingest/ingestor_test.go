@@ -0,0 +1,373 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/storage/memory"
+	"example.com/goAssignment1/internal/wal"
+)
+
+func openWAL(t *testing.T, dir string) *wal.Log {
+	t.Helper()
+	l, err := wal.Open(wal.Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	return l
+}
+
+// newTestIngestor fills in small, fast defaults for everything opts leaves
+// unset; the WAL is closed when the test ends.
+func newTestIngestor(t *testing.T, opts Options) *Ingestor {
+	t.Helper()
+	if opts.WAL == nil {
+		opts.WAL = openWAL(t, t.TempDir())
+	}
+	t.Cleanup(func() { _ = opts.WAL.Close() })
+	if opts.Receipts == nil {
+		opts.Receipts = NewReceiptStore(time.Hour, 1000)
+	}
+	if opts.QueueMaxSize == 0 {
+		opts.QueueMaxSize = 1000
+	}
+	if opts.BatchMaxSize == 0 {
+		opts.BatchMaxSize = 10
+	}
+	if opts.BatchMaxWait == 0 {
+		opts.BatchMaxWait = 10 * time.Millisecond
+	}
+	if opts.Retry == (RetryPolicy{}) {
+		opts.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	}
+	return NewIngestor(opts)
+}
+
+func event(user string) domain.Event {
+	return domain.Event{EventName: "page_view", UserID: user, Timestamp: 1_700_000_000}
+}
+
+func stop(t *testing.T, ig *Ingestor) StopReport {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rep, err := ig.Stop(ctx)
+	if err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	return rep
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func users(evs []domain.Event) map[string]int {
+	out := map[string]int{}
+	for _, ev := range evs {
+		out[ev.UserID]++
+	}
+	return out
+}
+
+func TestReplayQuarantinesUndecodableRecords(t *testing.T) {
+	dir := t.TempDir()
+	l := openWAL(t, dir)
+	entry := func(user string, extra map[string]any) []byte {
+		m := map[string]any{"receipt": "r1", "index": 0, "event": event(user)}
+		for k, v := range extra {
+			m[k] = v
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	payloads := [][]byte{
+		entry("u1", nil),
+		[]byte("{not json"),
+		[]byte(`{"receipt":"r2","index":0}`), // no event
+		entry("u2", map[string]any{"written_by": "a newer version"}),
+	}
+	if _, err := l.AppendBatch(payloads); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := memory.NewSink()
+	ig := newTestIngestor(t, Options{WAL: openWAL(t, dir), Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	waitFor(t, "replay", func() bool { return ig.wal.Pending() == 0 })
+	rep := stop(t, ig)
+
+	if got := users(sink.Events()); len(got) != 2 || got["u1"] != 1 || got["u2"] != 1 {
+		t.Fatalf("replayed users = %v, want u1 and u2 (unknown fields are ignored)", got)
+	}
+	if rep.Abandoned != 0 {
+		t.Fatalf("abandoned = %d, want 0", rep.Abandoned)
+	}
+	for seq, want := range map[int][]byte{2: payloads[1], 3: payloads[2]} {
+		b, err := os.ReadFile(filepath.Join(dir, "quarantine", fmt.Sprintf("%020d.rec", seq)))
+		if err != nil {
+			t.Fatalf("seq %d not quarantined: %v", seq, err)
+		}
+		if string(b) != string(want) {
+			t.Fatalf("quarantined seq %d = %q, want %q", seq, b, want)
+		}
+	}
+}
+
+// writeLog records the size of every write a memory sink receives.
+type writeLog struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (w *writeLog) fault(_ context.Context, events []domain.Event) error {
+	w.mu.Lock()
+	w.sizes = append(w.sizes, len(events))
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *writeLog) get() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]int(nil), w.sizes...)
+}
+
+func enqueueUsers(t *testing.T, ig *Ingestor, n int) *Ticket {
+	t.Helper()
+	evs := make([]domain.Event, n)
+	for i := range evs {
+		evs[i] = event(fmt.Sprintf("u%d", i))
+	}
+	tk, err := ig.EnqueueMany(evs)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	return tk
+}
+
+func wait(t *testing.T, tk *Ticket) []Outcome {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	outs, err := tk.Wait(ctx)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	return outs
+}
+
+func TestBatchesAreCutAtMaxSize(t *testing.T) {
+	var writes writeLog
+	sink := memory.NewSink()
+	sink.Fault = writes.fault
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}, BatchMaxSize: 5, BatchMaxWait: time.Hour})
+	ig.Start()
+
+	enqueueUsers(t, ig, 12)
+	waitFor(t, "two full batches", func() bool { return len(sink.Events()) == 10 })
+	time.Sleep(20 * time.Millisecond)
+	if got := writes.get(); !slices.Equal(got, []int{5, 5}) {
+		t.Fatalf("writes before stop = %v, want [5 5] (the partial batch waits for BatchMaxWait)", got)
+	}
+
+	rep := stop(t, ig)
+	if got := writes.get(); !slices.Equal(got, []int{5, 5, 2}) {
+		t.Fatalf("writes after stop = %v, want [5 5 2]", got)
+	}
+	if rep.Flushed != 2 || rep.Abandoned != 0 {
+		t.Fatalf("stop report = %+v, want 2 flushed, 0 abandoned", rep)
+	}
+}
+
+func TestPartialBatchIsFlushedAfterMaxWait(t *testing.T) {
+	var writes writeLog
+	sink := memory.NewSink()
+	sink.Fault = writes.fault
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}, BatchMaxSize: 100, BatchMaxWait: 20 * time.Millisecond})
+	ig.Start()
+	defer stop(t, ig)
+
+	outs := wait(t, enqueueUsers(t, ig, 3))
+	for i, o := range outs {
+		if o.Status != StatusInserted {
+			t.Fatalf("outcome %d = %+v, want inserted", i, o)
+		}
+	}
+	if got := writes.get(); !slices.Equal(got, []int{3}) {
+		t.Fatalf("writes = %v, want one batch of 3", got)
+	}
+}
+
+func TestDuplicatesAreReported(t *testing.T) {
+	sink := memory.NewSink()
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	defer stop(t, ig)
+
+	outs := wait(t, mustEnqueue(t, ig, event("u1"), event("u1"), event("u2")))
+	want := []Status{StatusInserted, StatusDuplicate, StatusInserted}
+	for i, o := range outs {
+		if o.Status != want[i] {
+			t.Fatalf("outcome %d = %s, want %s", i, o.Status, want[i])
+		}
+	}
+	waitFor(t, "ack", func() bool { return ig.wal.Pending() == 0 })
+}
+
+func TestWALAckWaitsForSinkCommit(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	sink := memory.NewSink()
+	var once sync.Once
+	sink.Fault = func(context.Context, []domain.Event) error {
+		once.Do(func() { close(entered) })
+		<-release
+		return nil
+	}
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	defer stop(t, ig)
+
+	tk := mustEnqueue(t, ig, event("u1"))
+	<-entered
+	time.Sleep(20 * time.Millisecond)
+	if p, cp := ig.wal.Pending(), ig.wal.Checkpoint(); p != 1 || cp != 0 {
+		t.Fatalf("while the write is in flight: pending=%d checkpoint=%d, want 1 and 0", p, cp)
+	}
+	select {
+	case <-tk.Done():
+		t.Fatal("ticket resolved before the sink committed")
+	default:
+	}
+
+	close(release)
+	if outs := wait(t, tk); outs[0].Status != StatusInserted {
+		t.Fatalf("outcome = %+v, want inserted", outs[0])
+	}
+	waitFor(t, "ack", func() bool { return ig.wal.Pending() == 0 && ig.wal.Checkpoint() == 1 })
+}
+
+func mustEnqueue(t *testing.T, ig *Ingestor, evs ...domain.Event) *Ticket {
+	t.Helper()
+	tk, err := ig.EnqueueMany(evs)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	return tk
+}
+
+func TestStopDrainsQueuedAndInFlightBatches(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	sink := memory.NewSink()
+	sink.Fault = func(context.Context, []domain.Event) error {
+		once.Do(func() { close(entered) })
+		<-release
+		return nil
+	}
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}, BatchMaxSize: 5, BatchMaxWait: time.Hour})
+	ig.Start()
+
+	// the first batch is held inside the sink, the rest waits in the queue
+	enqueueUsers(t, ig, 12)
+	<-entered
+
+	type result struct {
+		rep StopReport
+		err error
+	}
+	stopped := make(chan result, 1)
+	go func() {
+		rep, err := ig.Stop(context.Background())
+		stopped <- result{rep, err}
+	}()
+	<-ig.stopCh
+	if _, err := ig.Enqueue(event("late")); !errors.Is(err, ErrStopped) {
+		t.Fatalf("enqueue after Stop: %v, want ErrStopped", err)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a batch was still being written")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	res := <-stopped
+	if res.err != nil {
+		t.Fatalf("stop: %v", res.err)
+	}
+	if res.rep.Flushed != 12 || res.rep.Abandoned != 0 {
+		t.Fatalf("stop report = %+v, want 12 flushed, 0 abandoned", res.rep)
+	}
+	if got := users(sink.Events()); len(got) != 12 || got["late"] != 0 {
+		t.Fatalf("stored users = %v, want the 12 enqueued before Stop", got)
+	}
+}
+
+func TestStopDeadlineLeavesUndrainedRecordsInWAL(t *testing.T) {
+	dir := t.TempDir()
+	entered := make(chan struct{})
+	var once sync.Once
+	stuck := memory.NewSink()
+	// a sink that hangs until its write is cancelled
+	stuck.Fault = func(ctx context.Context, _ []domain.Event) error {
+		once.Do(func() { close(entered) })
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	ig := newTestIngestor(t, Options{WAL: openWAL(t, dir), Sinks: []SinkSpec{{Sink: stuck}}, BatchMaxSize: 5, BatchMaxWait: time.Hour})
+	ig.Start()
+	enqueueUsers(t, ig, 12)
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rep, err := ig.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stop err = %v, want deadline exceeded", err)
+	}
+	if rep.Flushed != 0 || rep.Abandoned != 12 {
+		t.Fatalf("stop report = %+v, want 0 flushed, 12 abandoned", rep)
+	}
+	if n := len(stuck.Events()); n != 0 {
+		t.Fatalf("stored %d events, want 0", n)
+	}
+	if err := ig.wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything comes back on the next start
+	sink := memory.NewSink()
+	next := newTestIngestor(t, Options{WAL: openWAL(t, dir), Sinks: []SinkSpec{{Sink: sink}}})
+	next.Start()
+	waitFor(t, "replay", func() bool { return next.wal.Pending() == 0 })
+	stop(t, next)
+	if got := users(sink.Events()); len(got) != 12 {
+		t.Fatalf("replayed users = %v, want 12", got)
+	}
+}
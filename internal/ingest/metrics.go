@@ -0,0 +1,41 @@
+package ingest
+
+import (
+	"example.com/goAssignment1/internal/telemetry"
+)
+
+var (
+	mEnqueued = telemetry.Default.NewCounter("events_api_ingest_enqueued_total",
+		"Events durably written to the WAL and queued.")
+	mRejected = telemetry.Default.NewCounterVec("events_api_ingest_rejected_total",
+		"Events refused by Enqueue.", "reason")
+	mOutcomes = telemetry.Default.NewCounterVec("events_api_ingest_outcomes_total",
+		"Final per-event outcomes (inserted, duplicate, failed, dead_lettered).", "status")
+	mQuarantined = telemetry.Default.NewCounter("events_api_wal_quarantined_total",
+		"Undecodable WAL records copied to WAL_DIR/quarantine during replay.")
+	mBatchSize = telemetry.Default.NewHistogram("events_api_ingest_batch_size",
+		"Events per batch handed to the writer workers.", telemetry.SizeBuckets)
+	mSinkEvents = telemetry.Default.NewCounterVec("events_api_sink_events_total",
+		"Events handled per sink and result.", "sink", "status")
+	mSinkWriteSeconds = telemetry.Default.NewHistogramVec("events_api_sink_write_duration_seconds",
+		"Duration of a single sink write attempt.", telemetry.LatencyBuckets, "sink")
+	mSinkRetries = telemetry.Default.NewCounterVec("events_api_sink_retries_total",
+		"Retried sink writes after a transient error.", "sink")
+)
+
+// registerGauges exposes the live queue/WAL/batching state of ig.
+func (ig *Ingestor) registerGauges() {
+	r := telemetry.Default
+	r.SetGaugeFunc("events_api_ingest_queue_depth", "Events waiting in the in-memory queue.",
+		func() float64 { return float64(len(ig.queue)) })
+	r.SetGaugeFunc("events_api_ingest_queue_capacity", "Capacity of the in-memory queue.",
+		func() float64 { return float64(cap(ig.queue)) })
+	r.SetGaugeFunc("events_api_wal_pending_events", "Events in the WAL not yet acknowledged.",
+		func() float64 { return float64(ig.wal.Pending()) })
+	r.SetGaugeFunc("events_api_ingest_redrive_pending", "Transiently failed events waiting to be re-driven.",
+		func() float64 { return float64(ig.redrives.len()) })
+	r.SetGaugeFunc("events_api_ingest_batch_target_size", "Current batch size limit.",
+		func() float64 { size, _ := ig.tuner.current(); return float64(size) })
+	r.SetGaugeFunc("events_api_ingest_batch_wait_seconds", "Current batch wait time.",
+		func() float64 { _, wait := ig.tuner.current(); return wait.Seconds() })
+}
@@ -0,0 +1,201 @@
+package ingest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+)
+
+// ReceiptState is the lifecycle state of a receipt or of one event in it.
+type ReceiptState string
+
+const (
+	ReceiptQueued       ReceiptState = "queued"
+	ReceiptCommitted    ReceiptState = "committed"
+	ReceiptDuplicate    ReceiptState = "duplicate"
+	ReceiptRetrying     ReceiptState = "retrying" // failed transiently; re-driven from the WAL
+	ReceiptDeadLettered ReceiptState = "dead_lettered"
+)
+
+// Receipt describes what happened to the events of one ingest request.
+//
+// Status aggregates the event states with precedence
+// queued > retrying > dead_lettered > committed > duplicate, i.e. a receipt is
+// "committed" only once every event is committed or a duplicate. The only
+// terminal failure is "dead_lettered"; "retrying" events are still in the WAL
+// and keep being re-driven.
+//
+// Receipts are kept in memory. After a restart, receipts are rebuilt from the
+// WAL replay and only list events that were still pending at the time.
+type Receipt struct {
+	ID        string         `json:"id"`
+	Status    ReceiptState   `json:"status"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Counts    map[string]int `json:"counts"`
+	Events    []ReceiptEvent `json:"events"`
+}
+
+type ReceiptEvent struct {
+	Index     int          `json:"index"`
+	EventID   string       `json:"event_id,omitempty"`
+	EventName string       `json:"event_name"`
+	UserID    string       `json:"user_id"`
+	Timestamp int64        `json:"timestamp"`
+	Status    ReceiptState `json:"status"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// ReceiptStore is a bounded in-memory index of receipts. Entries expire after
+// ttl and the oldest are evicted beyond maxEntries.
+type ReceiptStore struct {
+	mu         sync.Mutex
+	byID       map[string]*Receipt
+	order      []string // creation order, for eviction
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+func NewReceiptStore(ttl time.Duration, maxEntries int) *ReceiptStore {
+	return &ReceiptStore{
+		byID:       make(map[string]*Receipt),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Get returns a snapshot of the receipt.
+func (s *ReceiptStore) Get(id string) (Receipt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[id]
+	if !ok || s.now().Sub(r.CreatedAt) > s.ttl {
+		return Receipt{}, false
+	}
+	cp := *r
+	cp.Events = append([]ReceiptEvent(nil), r.Events...)
+	cp.Counts = make(map[string]int, len(r.Counts))
+	for k, v := range r.Counts {
+		cp.Counts[k] = v
+	}
+	return cp, true
+}
+
+// create registers a new receipt for events.
+func (s *ReceiptStore) create(id string, evs []domain.Event) {
+	now := s.now()
+	r := &Receipt{ID: id, CreatedAt: now, UpdatedAt: now, Events: make([]ReceiptEvent, len(evs))}
+	for i := range evs {
+		r.Events[i] = receiptEvent(i, evs[i])
+	}
+	s.mu.Lock()
+	s.insertLocked(r)
+	s.mu.Unlock()
+}
+
+// restore re-attaches a replayed WAL event to its receipt, creating it if needed.
+func (s *ReceiptStore) restore(id string, idx int, ev domain.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[id]
+	if !ok {
+		now := s.now()
+		r = &Receipt{ID: id, CreatedAt: now, UpdatedAt: now}
+		s.insertLocked(r)
+	}
+	r.Events = append(r.Events, receiptEvent(idx, ev))
+	r.recount()
+}
+
+// resolve records the final state of one event.
+func (s *ReceiptStore) resolve(id string, idx int, o Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.byID[id]
+	if !ok {
+		return
+	}
+	for i := range r.Events {
+		if r.Events[i].Index != idx {
+			continue
+		}
+		r.Events[i].Status = receiptState(o.Status)
+		if o.Err != nil {
+			r.Events[i].Error = o.Err.Error()
+		} else {
+			r.Events[i].Error = ""
+		}
+		break
+	}
+	r.UpdatedAt = s.now()
+	r.recount()
+}
+
+func (s *ReceiptStore) insertLocked(r *Receipt) {
+	r.recount()
+	s.byID[r.ID] = r
+	s.order = append(s.order, r.ID)
+
+	now := s.now()
+	drop := 0
+	for drop < len(s.order) {
+		old, ok := s.byID[s.order[drop]]
+		if ok && len(s.order)-drop <= s.maxEntries && now.Sub(old.CreatedAt) <= s.ttl {
+			break
+		}
+		delete(s.byID, s.order[drop])
+		drop++
+	}
+	if drop > 0 {
+		s.order = append(s.order[:0], s.order[drop:]...)
+	}
+}
+
+func (r *Receipt) recount() {
+	r.Counts = map[string]int{}
+	for _, e := range r.Events {
+		r.Counts[string(e.Status)]++
+	}
+	for _, st := range []ReceiptState{ReceiptQueued, ReceiptRetrying, ReceiptDeadLettered, ReceiptCommitted, ReceiptDuplicate} {
+		if r.Counts[string(st)] > 0 {
+			r.Status = st
+			return
+		}
+	}
+	r.Status = ReceiptQueued
+}
+
+func receiptEvent(idx int, ev domain.Event) ReceiptEvent {
+	return ReceiptEvent{
+		Index:     idx,
+		EventID:   ev.EventID,
+		EventName: ev.EventName,
+		UserID:    ev.UserID,
+		Timestamp: ev.Timestamp,
+		Status:    ReceiptQueued,
+	}
+}
+
+func receiptState(s Status) ReceiptState {
+	switch s {
+	case StatusInserted:
+		return ReceiptCommitted
+	case StatusDuplicate:
+		return ReceiptDuplicate
+	case StatusDeadLettered:
+		return ReceiptDeadLettered
+	default:
+		return ReceiptRetrying
+	}
+}
+
+func newReceiptID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "rcpt_" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,77 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+)
+
+// Transiently failed events are re-driven in-process: they stay unacknowledged
+// in the WAL and are handed back to the batcher after a growing delay, so the
+// checkpoint (and segment GC) resumes as soon as the sinks recover.
+const (
+	redriveTick     = 500 * time.Millisecond
+	maxRedriveDelay = time.Minute
+)
+
+// redriveQueue holds failed records until their next attempt is due.
+type redriveQueue struct {
+	mu   sync.Mutex
+	recs []redriveRec
+}
+
+type redriveRec struct {
+	rec record
+	due time.Time
+}
+
+func (q *redriveQueue) add(rec record, due time.Time) {
+	q.mu.Lock()
+	q.recs = append(q.recs, redriveRec{rec: rec, due: due})
+	q.mu.Unlock()
+}
+
+// takeDue removes and returns the records due at now, oldest first.
+func (q *redriveQueue) takeDue(now time.Time) []record {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []record
+	keep := q.recs[:0]
+	for _, r := range q.recs {
+		if r.due.After(now) {
+			keep = append(keep, r)
+			continue
+		}
+		out = append(out, r.rec)
+	}
+	clear(q.recs[len(keep):])
+	q.recs = keep
+	return out
+}
+
+func (q *redriveQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.recs)
+}
+
+// redriveDelay is the wait before the n-th re-drive of an event: the retry
+// policy's MaxDelay doubled per re-drive, capped at maxRedriveDelay.
+func (p RetryPolicy) redriveDelay(n int) time.Duration {
+	d := p.MaxDelay
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < n && d < maxRedriveDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRedriveDelay)
+}
+
+// redrive schedules a transiently failed record for another attempt. Its
+// ticket has already been resolved (the waiting caller is told the events are
+// kept and retried), so later outcomes only update the receipt.
+func (ig *Ingestor) redrive(rec record) {
+	rec.ticket = nil
+	rec.redrives++
+	ig.redrives.add(rec, time.Now().Add(ig.retry.redriveDelay(rec.redrives)))
+}
@@ -0,0 +1,179 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/storage/memory"
+)
+
+func TestTransientFailureIsRedrivenUntilStored(t *testing.T) {
+	var calls atomic.Int32
+	sink := memory.NewSink()
+	sink.Fault = func(context.Context, []domain.Event) error {
+		if calls.Add(1) <= 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	ig := newTestIngestor(t, Options{
+		Sinks: []SinkSpec{{Sink: sink}},
+		Retry: RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	ig.Start()
+	defer stop(t, ig)
+
+	tk := mustEnqueue(t, ig, event("u1"))
+	// the caller hears about the first failure; the event stays in the WAL
+	if outs := wait(t, tk); outs[0].Status != StatusFailed {
+		t.Fatalf("outcome = %+v, want failed", outs[0])
+	}
+	if p := ig.wal.Pending(); p != 1 {
+		t.Fatalf("pending after failure = %d, want 1", p)
+	}
+
+	waitFor(t, "redrive", func() bool { return len(sink.Events()) == 1 })
+	waitFor(t, "ack", func() bool { return ig.wal.Pending() == 0 })
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("writes = %d, want 3", n)
+	}
+	r, ok := ig.Receipts().Get(tk.ReceiptID())
+	if !ok || r.Status != ReceiptCommitted {
+		t.Fatalf("receipt = %+v, want committed", r)
+	}
+}
+
+// parkedStore wraps the memory sink's dead letters and checks the ingestor's
+// WAL at the moment entries are taken or restored.
+type parkedStore struct {
+	sink          *memory.Sink
+	ig            *Ingestor
+	takeErr       error
+	afterTake     func()
+	pendingAtTake uint64
+	restoreCtx    error
+	restored      int
+}
+
+func (s *parkedStore) TakeDeadLetters(ctx context.Context, ids []int64) ([]domain.DeadLetter, error) {
+	s.pendingAtTake = s.ig.wal.Pending()
+	if s.takeErr != nil {
+		return nil, s.takeErr
+	}
+	dls, err := s.sink.TakeDeadLetters(ctx, ids)
+	if s.afterTake != nil {
+		s.afterTake()
+	}
+	return dls, err
+}
+
+func (s *parkedStore) RestoreDeadLetters(ctx context.Context, dls []domain.DeadLetter) error {
+	s.restoreCtx = ctx.Err()
+	s.restored += len(dls)
+	return s.sink.RestoreDeadLetters(ctx, dls)
+}
+
+// parkOne dead-letters u1 through ig and then lets the sink accept it.
+func parkOne(t *testing.T, ig *Ingestor, sink *memory.Sink, reject *atomic.Bool) domain.DeadLetter {
+	t.Helper()
+	reject.Store(true)
+	if outs := wait(t, mustEnqueue(t, ig, event("u1"))); outs[0].Status != StatusDeadLettered {
+		t.Fatalf("outcome = %+v, want dead_lettered", outs[0])
+	}
+	reject.Store(false)
+	waitFor(t, "ack", func() bool { return ig.wal.Pending() == 0 })
+	dead := sink.DeadLetters()
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %+v, want 1", dead)
+	}
+	return dead[0]
+}
+
+func rejectingSink(reject *atomic.Bool) *memory.Sink {
+	sink := memory.NewSink()
+	sink.Fault = func(_ context.Context, events []domain.Event) error {
+		if reject.Load() {
+			return memory.ErrRejected
+		}
+		return nil
+	}
+	return sink
+}
+
+func TestRedriveDeadLettersEnqueuesAfterTake(t *testing.T) {
+	var reject atomic.Bool
+	sink := rejectingSink(&reject)
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	defer stop(t, ig)
+	dl := parkOne(t, ig, sink, &reject)
+
+	// a delete that does not commit enqueues nothing and keeps the entry
+	store := &parkedStore{sink: sink, ig: ig, takeErr: errors.New("serialization failure")}
+	if _, _, err := ig.RedriveDeadLetters(context.Background(), store, []int64{dl.ID}); !errors.Is(err, store.takeErr) {
+		t.Fatalf("redrive err = %v, want the take error", err)
+	}
+	if p := ig.wal.Pending(); p != 0 || len(sink.DeadLetters()) != 1 || store.restored != 0 {
+		t.Fatalf("after failed take: pending=%d parked=%d restored=%d, want 0, 1, 0", p, len(sink.DeadLetters()), store.restored)
+	}
+
+	store = &parkedStore{sink: sink, ig: ig}
+	tk, n, err := ig.RedriveDeadLetters(context.Background(), store, []int64{dl.ID, 999})
+	if err != nil || n != 1 {
+		t.Fatalf("redrive = %d, %v; want 1, nil", n, err)
+	}
+	if store.pendingAtTake != 0 {
+		t.Fatalf("%d events were in the WAL before the take committed", store.pendingAtTake)
+	}
+	if outs := wait(t, tk); outs[0].Status != StatusInserted {
+		t.Fatalf("redriven outcome = %+v, want inserted", outs[0])
+	}
+	if got := users(sink.Events()); got["u1"] != 1 || len(sink.DeadLetters()) != 0 {
+		t.Fatalf("stored = %v, parked = %+v; want u1 stored and nothing parked", got, sink.DeadLetters())
+	}
+}
+
+func TestFailedRedriveParksDeadLettersAgain(t *testing.T) {
+	var reject atomic.Bool
+	sink := rejectingSink(&reject)
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	dl := parkOne(t, ig, sink, &reject)
+
+	t.Run("queue full", func(t *testing.T) {
+		// not started, so the single queue slot stays taken
+		full := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}, QueueMaxSize: 1})
+		mustEnqueue(t, full, event("filler"))
+		// the caller goes away while the redrive is in progress
+		ctx, cancel := context.WithCancel(context.Background())
+		store := &parkedStore{sink: sink, ig: full, afterTake: cancel}
+		tk, n, err := full.RedriveDeadLetters(ctx, store, []int64{dl.ID})
+		if !errors.Is(err, ErrQueueFull) || tk != nil || n != 0 {
+			t.Fatalf("redrive = %v, %d, %v; want ErrQueueFull", tk, n, err)
+		}
+		if store.restored != 1 || store.restoreCtx != nil {
+			t.Fatalf("restored %d (ctx err %v), want 1 on a live context", store.restored, store.restoreCtx)
+		}
+		if got := sink.DeadLetters(); len(got) != 1 || got[0].ID != dl.ID || got[0].Error != dl.Error || !got[0].CreatedAt.Equal(dl.CreatedAt) {
+			t.Fatalf("parked = %+v, want %+v back under its id", got, dl)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		stop(t, ig)
+		store := &parkedStore{sink: sink, ig: ig}
+		if _, _, err := ig.RedriveDeadLetters(context.Background(), store, []int64{dl.ID}); !errors.Is(err, ErrStopped) {
+			t.Fatalf("redrive err = %v, want ErrStopped", err)
+		}
+		if got := sink.DeadLetters(); len(got) != 1 || got[0].ID != dl.ID {
+			t.Fatalf("parked = %+v, want %d back", got, dl.ID)
+		}
+		if got := users(sink.Events()); got["u1"] != 0 {
+			t.Fatalf("u1 stored %d times, want 0", got["u1"])
+		}
+	})
+}
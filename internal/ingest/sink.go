@@ -0,0 +1,128 @@
+package ingest
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+)
+
+// Sink is a destination for batches of events.
+type Sink interface {
+	Name() string
+	// Write persists a batch. inserted[i] reports whether events[i] was new
+	// (false = duplicate). Sinks that cannot detect duplicates report true.
+	Write(ctx context.Context, events []domain.Event) (inserted []bool, err error)
+}
+
+// ErrorClassifier is implemented by sinks that can tell transient errors from
+// permanent (data) errors. Without it, every error is treated as transient.
+type ErrorClassifier interface {
+	IsTransient(err error) bool
+}
+
+// DeadLetterer is implemented by sinks that can park permanently rejected events.
+type DeadLetterer interface {
+	DeadLetter(ctx context.Context, ev domain.Event, reason string) error
+}
+
+// SinkSpec configures one sink of the ingestor.
+//
+// Required sinks gate acknowledgement: an event leaves the WAL only once every
+// required sink has stored (or dead-lettered) it, so required sinks are
+// at-least-once and must tolerate replays. Best-effort sinks get the same
+// retries, but their failures are only logged. Per-event outcomes (inserted vs
+// duplicate) are taken from the first required sink.
+type SinkSpec struct {
+	Sink       Sink
+	BestEffort bool
+}
+
+// deliver writes recs to one sink and fills outs (same length as recs).
+//
+// Transient errors are retried with jittered exponential backoff. Permanent
+// errors bisect the batch until the poisonous rows are isolated; those are
+// dead-lettered if the sink supports it, otherwise reported as failed.
+func (ig *Ingestor) deliver(ctx context.Context, s Sink, recs []record, outs []Outcome) {
+	events := make([]domain.Event, len(recs))
+	for i, rec := range recs {
+		events[i] = rec.ev
+	}
+
+	inserted, err := ig.writeWithRetry(ctx, s, events)
+	switch {
+	case err == nil:
+		affected := 0
+		for i := range recs {
+			if inserted[i] {
+				affected++
+				outs[i] = Outcome{Status: StatusInserted}
+			} else {
+				outs[i] = Outcome{Status: StatusDuplicate}
+			}
+		}
+		log.Printf("[ingest] %s batch write OK: inserted=%d size=%d", s.Name(), affected, len(recs))
+	case isTransient(s, err):
+		log.Printf("[ingest] %s batch write FAILED: err=%v size=%d", s.Name(), err, len(recs))
+		for i := range outs {
+			outs[i] = Outcome{Status: StatusFailed, Err: err}
+		}
+	case len(recs) == 1:
+		outs[0] = ig.deadLetter(ctx, s, recs[0], err)
+	default:
+		log.Printf("[ingest] %s batch write rejected: err=%v size=%d (bisecting)", s.Name(), err, len(recs))
+		mid := len(recs) / 2
+		ig.deliver(ctx, s, recs[:mid], outs[:mid])
+		ig.deliver(ctx, s, recs[mid:], outs[mid:])
+		return
+	}
+	for _, o := range outs {
+		mSinkEvents.With(s.Name(), string(o.Status)).Inc()
+	}
+}
+
+func (ig *Ingestor) writeWithRetry(ctx context.Context, s Sink, events []domain.Event) ([]bool, error) {
+	var lastErr error
+	for attempt := 0; attempt < ig.retry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			mSinkRetries.With(s.Name()).Inc()
+			d := ig.retry.backoff(attempt)
+			log.Printf("[ingest] %s transient write error, retrying in %s (attempt %d/%d): %v", s.Name(), d, attempt+1, ig.retry.MaxAttempts, lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, lastErr
+			case <-time.After(d):
+			}
+		}
+		start := time.Now()
+		inserted, err := s.Write(ctx, events)
+		mSinkWriteSeconds.With(s.Name()).Observe(time.Since(start).Seconds())
+		if err == nil || !isTransient(s, err) {
+			return inserted, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (ig *Ingestor) deadLetter(ctx context.Context, s Sink, rec record, cause error) Outcome {
+	dl, ok := s.(DeadLetterer)
+	if !ok {
+		log.Printf("[ingest] %s rejected event permanently: seq=%d name=%s user=%s err=%v (no dead-letter support)", s.Name(), rec.seq, rec.ev.EventName, rec.ev.UserID, cause)
+		return Outcome{Status: StatusFailed, Err: cause}
+	}
+	if err := dl.DeadLetter(ctx, rec.ev, cause.Error()); err != nil {
+		log.Printf("[ingest] %s dead-letter FAILED: seq=%d err=%v", s.Name(), rec.seq, err)
+		return Outcome{Status: StatusFailed, Err: err}
+	}
+	log.Printf("[ingest] %s dead-lettered event: seq=%d name=%s user=%s err=%v", s.Name(), rec.seq, rec.ev.EventName, rec.ev.UserID, cause)
+	return Outcome{Status: StatusDeadLettered, Err: cause}
+}
+
+func isTransient(s Sink, err error) bool {
+	if c, ok := s.(ErrorClassifier); ok {
+		return c.IsTransient(err)
+	}
+	return true
+}
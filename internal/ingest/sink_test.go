@@ -0,0 +1,115 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/storage/memory"
+)
+
+func TestTransientErrorsAreRetried(t *testing.T) {
+	var calls atomic.Int32
+	sink := memory.NewSink()
+	sink.Fault = func(context.Context, []domain.Event) error {
+		if calls.Add(1) <= 2 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	defer stop(t, ig)
+
+	outs := wait(t, enqueueUsers(t, ig, 4))
+	for i, o := range outs {
+		if o.Status != StatusInserted {
+			t.Fatalf("outcome %d = %+v, want inserted", i, o)
+		}
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("writes = %d, want 3 (two transient failures, then success)", n)
+	}
+	if n := len(sink.Events()); n != 4 {
+		t.Fatalf("stored %d events, want 4", n)
+	}
+}
+
+// rejectUser makes every write containing user fail permanently.
+func rejectUser(user string) func(context.Context, []domain.Event) error {
+	return func(_ context.Context, events []domain.Event) error {
+		for _, ev := range events {
+			if ev.UserID == user {
+				return fmt.Errorf("%w: bad user %s", memory.ErrRejected, user)
+			}
+		}
+		return nil
+	}
+}
+
+func TestBisectDeadLettersOnlyTheBadRecord(t *testing.T) {
+	var calls atomic.Int32
+	reject := rejectUser("u5")
+	sink := memory.NewSink()
+	sink.Fault = func(ctx context.Context, events []domain.Event) error {
+		calls.Add(1)
+		return reject(ctx, events)
+	}
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: sink}}})
+	ig.Start()
+	defer stop(t, ig)
+
+	outs := wait(t, enqueueUsers(t, ig, 8))
+	for i, o := range outs {
+		want := StatusInserted
+		if i == 5 {
+			want = StatusDeadLettered
+		}
+		if o.Status != want {
+			t.Fatalf("outcome %d = %+v, want %s", i, o, want)
+		}
+	}
+	if got := users(sink.Events()); len(got) != 7 || got["u5"] != 0 {
+		t.Fatalf("stored users = %v, want all but u5", got)
+	}
+	dead := sink.DeadLetters()
+	if len(dead) != 1 || dead[0].Event.UserID != "u5" || !errors.Is(outs[5].Err, memory.ErrRejected) {
+		t.Fatalf("dead letters = %+v (err %v), want only u5", dead, outs[5].Err)
+	}
+	// 8 -> 4+4 -> 2+2 -> 1+1: one rejected write per level, then the good halves
+	if n := calls.Load(); n != 7 {
+		t.Fatalf("writes = %d, want 7", n)
+	}
+	// dead-lettered events are done with: they leave the WAL too
+	waitFor(t, "ack", func() bool { return ig.wal.Pending() == 0 })
+}
+
+// noDeadLetters hides the memory sink's DeadLetter method.
+type noDeadLetters struct{ s *memory.Sink }
+
+func (s noDeadLetters) Name() string { return s.s.Name() }
+func (s noDeadLetters) Write(ctx context.Context, events []domain.Event) ([]bool, error) {
+	return s.s.Write(ctx, events)
+}
+func (s noDeadLetters) IsTransient(err error) bool { return s.s.IsTransient(err) }
+
+func TestRejectedRecordFailsWithoutDeadLetterSupport(t *testing.T) {
+	sink := memory.NewSink()
+	sink.Fault = rejectUser("u1")
+	ig := newTestIngestor(t, Options{Sinks: []SinkSpec{{Sink: noDeadLetters{sink}}}})
+	ig.Start()
+	defer stop(t, ig)
+
+	outs := wait(t, enqueueUsers(t, ig, 2))
+	if outs[0].Status != StatusInserted || outs[1].Status != StatusFailed {
+		t.Fatalf("outcomes = %+v, want inserted, failed", outs)
+	}
+	// the rejected event is kept in the WAL for a later redrive
+	waitFor(t, "ack of u0", func() bool { return ig.wal.Checkpoint() == 1 })
+	if p := ig.wal.Pending(); p != 1 {
+		t.Fatalf("pending = %d, want 1", p)
+	}
+}
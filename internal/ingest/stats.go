@@ -0,0 +1,77 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stats is a point-in-time view of the ingestor for ops endpoints.
+type Stats struct {
+	QueueDepth    int           `json:"queue_depth"`
+	QueueCapacity int           `json:"queue_capacity"`
+	WALCheckpoint uint64        `json:"wal_checkpoint"`
+	WALPending    uint64        `json:"wal_pending"`
+	Redriving     int           `json:"redriving"` // failed events waiting for their next attempt
+	Batching      BatchingStats `json:"batching"`
+	Workers       []WorkerStats `json:"workers"`
+}
+
+// WorkerStats are cumulative counters of one writer worker.
+type WorkerStats struct {
+	ID            int       `json:"id"`
+	Busy          bool      `json:"busy"`
+	Batches       int64     `json:"batches"`
+	Events        int64     `json:"events"`
+	FailedEvents  int64     `json:"failed_events"`
+	LastBatchSize int       `json:"last_batch_size"`
+	LastLatencyMs float64   `json:"last_latency_ms"`
+	LastBatchAt   time.Time `json:"last_batch_at,omitzero"`
+}
+
+type worker struct {
+	id    int
+	mu    sync.Mutex
+	stats WorkerStats
+}
+
+func (ig *Ingestor) runBatch(ctx context.Context, wk *worker, job batchJob) {
+	batch := job.recs
+	wk.mu.Lock()
+	wk.stats.Busy = true
+	wk.mu.Unlock()
+
+	start := time.Now()
+	failed := ig.writeBatch(ctx, batch)
+	elapsed := time.Since(start)
+	ig.tuner.observe(len(batch), job.target, elapsed, float64(len(ig.queue))/float64(max(cap(ig.queue), 1)))
+
+	wk.mu.Lock()
+	wk.stats.Busy = false
+	wk.stats.Batches++
+	wk.stats.Events += int64(len(batch))
+	wk.stats.FailedEvents += int64(failed)
+	wk.stats.LastBatchSize = len(batch)
+	wk.stats.LastLatencyMs = float64(elapsed.Microseconds()) / 1000
+	wk.stats.LastBatchAt = start.UTC()
+	wk.mu.Unlock()
+}
+
+func (ig *Ingestor) Stats() Stats {
+	st := Stats{
+		QueueDepth:    len(ig.queue),
+		QueueCapacity: cap(ig.queue),
+		WALCheckpoint: ig.wal.Checkpoint(),
+		WALPending:    ig.wal.Pending(),
+		Redriving:     ig.redrives.len(),
+		Batching:      ig.tuner.stats(),
+		Workers:       make([]WorkerStats, len(ig.workers)),
+	}
+	for i, wk := range ig.workers {
+		wk.mu.Lock()
+		st.Workers[i] = wk.stats
+		st.Workers[i].ID = wk.id
+		wk.mu.Unlock()
+	}
+	return st
+}
@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/storage/memory"
+)
+
+func TestEveryRecordIsAckedOnceWithManyWorkers(t *testing.T) {
+	const total = 500
+	var (
+		mu     sync.Mutex
+		stored = map[string]int{}
+		writes atomic.Int32
+	)
+	sink := memory.NewSink()
+	sink.Fault = func(_ context.Context, events []domain.Event) error {
+		// every 5th write fails and is re-driven later, so batches finish
+		// out of order and the checkpoint has gaps to close
+		if writes.Add(1)%5 == 0 {
+			return errors.New("connection reset")
+		}
+		time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+		mu.Lock()
+		for _, ev := range events {
+			stored[ev.UserID]++
+		}
+		mu.Unlock()
+		return nil
+	}
+	ig := newTestIngestor(t, Options{
+		Sinks:        []SinkSpec{{Sink: sink}},
+		Workers:      4,
+		BatchMaxSize: 7,
+		Retry:        RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	ig.Start()
+	defer stop(t, ig)
+
+	for i := 0; i < total; i += 10 {
+		evs := make([]domain.Event, 10)
+		for j := range evs {
+			evs[j] = event(fmt.Sprintf("u%d", i+j))
+		}
+		mustEnqueue(t, ig, evs...)
+	}
+	waitFor(t, "all acks", func() bool { return ig.wal.Pending() == 0 })
+
+	if n := ig.committed.Load(); n != total {
+		t.Fatalf("acknowledged %d events, want exactly %d", n, total)
+	}
+	if cp := ig.wal.Checkpoint(); cp != total {
+		t.Fatalf("checkpoint = %d, want %d", cp, total)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stored) != total {
+		t.Fatalf("stored %d distinct users, want %d", len(stored), total)
+	}
+	for user, n := range stored {
+		if n != 1 {
+			t.Fatalf("%s committed %d times, want once", user, n)
+		}
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	const workers = 4
+	var (
+		inFlight atomic.Int32
+		allIn    = make(chan struct{})
+		release  = make(chan struct{})
+	)
+	sink := memory.NewSink()
+	sink.Fault = func(_ context.Context, events []domain.Event) error {
+		if inFlight.Add(1) == workers {
+			close(allIn)
+		}
+		<-release
+		if events[0].UserID == "u15" {
+			return fmt.Errorf("%w: no", memory.ErrRejected)
+		}
+		return nil
+	}
+	ig := newTestIngestor(t, Options{
+		Sinks:        []SinkSpec{{Sink: noDeadLetters{sink}}},
+		Workers:      workers,
+		BatchMaxSize: 5,
+		// keep the failed event from being re-driven while the test runs
+		Retry: RetryPolicy{MaxAttempts: 1, MaxDelay: time.Minute},
+	})
+	ig.Start()
+	defer stop(t, ig)
+
+	// four batches of five, one per worker, all held inside the sink
+	enqueueUsers(t, ig, 20)
+	<-allIn
+	st := ig.Stats()
+	if len(st.Workers) != workers {
+		t.Fatalf("got %d worker stats, want %d", len(st.Workers), workers)
+	}
+	for i, ws := range st.Workers {
+		if ws.ID != i || !ws.Busy || ws.Batches != 0 {
+			t.Fatalf("worker %d while writing = %+v, want busy with no batch done", i, ws)
+		}
+	}
+
+	close(release)
+	waitFor(t, "all batches", func() bool {
+		for _, ws := range ig.Stats().Workers {
+			if ws.Busy || ws.Batches != 1 {
+				return false
+			}
+		}
+		return true
+	})
+	var failed int64
+	for i, ws := range ig.Stats().Workers {
+		if ws.Events != 5 || ws.LastBatchSize != 5 || ws.LastBatchAt.IsZero() {
+			t.Fatalf("worker %d = %+v, want one batch of 5", i, ws)
+		}
+		failed += ws.FailedEvents
+	}
+	// the batch starting at u15 is bisected down to u15, which has nowhere to go
+	if failed != 1 {
+		t.Fatalf("failed events = %d, want 1", failed)
+	}
+}
@@ -0,0 +1,63 @@
+package ingest
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Status is the final state of a queued event.
+type Status string
+
+const (
+	StatusInserted     Status = "inserted"      // committed as a new row
+	StatusDuplicate    Status = "duplicate"     // committed batch, row already existed
+	StatusDeadLettered Status = "dead_lettered" // permanently rejected, parked in events_dead_letter
+	StatusFailed       Status = "failed"        // not written yet (transient); kept in the WAL and re-driven
+)
+
+// Outcome is what happened to one event once its batch was processed.
+type Outcome struct {
+	Status Status
+	Err    error
+}
+
+// Ticket tracks the outcomes of events submitted together in one Enqueue call.
+type Ticket struct {
+	id       string
+	outcomes []Outcome
+	pending  atomic.Int64
+	done     chan struct{}
+}
+
+func newTicket(receiptID string, n int) *Ticket {
+	t := &Ticket{id: receiptID, outcomes: make([]Outcome, n), done: make(chan struct{})}
+	t.pending.Store(int64(n))
+	if n == 0 {
+		close(t.done)
+	}
+	return t
+}
+
+func (t *Ticket) resolve(i int, o Outcome) {
+	t.outcomes[i] = o
+	if t.pending.Add(-1) == 0 {
+		close(t.done)
+	}
+}
+
+// ReceiptID identifies the receipt tracking these events.
+func (t *Ticket) ReceiptID() string { return t.id }
+
+// Done is closed once every event of the ticket has an outcome.
+func (t *Ticket) Done() <-chan struct{} { return t.done }
+
+// Wait blocks until every event has an outcome or ctx ends.
+// Outcomes are in submission order.
+func (t *Ticket) Wait(ctx context.Context) ([]Outcome, error) {
+	select {
+	case <-t.done:
+		return t.outcomes, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,181 @@
+package ingest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchBounds configures adaptive batching. With Adaptive=false the batcher
+// uses the fixed Options.BatchMaxSize / Options.BatchMaxWait.
+type BatchBounds struct {
+	Adaptive      bool
+	MinSize       int
+	MaxSize       int
+	MinWait       time.Duration
+	MaxWait       time.Duration
+	TargetLatency time.Duration // per-batch write latency the tuner aims to stay under
+}
+
+// Decision is one adjustment made by the tuner.
+type Decision struct {
+	At     time.Time `json:"at"`
+	Size   int       `json:"size"`
+	WaitMs int64     `json:"wait_ms"`
+	Reason string    `json:"reason"`
+}
+
+// BatchingStats is the tuner's state for ops endpoints.
+type BatchingStats struct {
+	Adaptive        bool       `json:"adaptive"`
+	Size            int        `json:"size"`
+	WaitMs          int64      `json:"wait_ms"`
+	MinSize         int        `json:"min_size,omitempty"`
+	MaxSize         int        `json:"max_size,omitempty"`
+	MinWaitMs       int64      `json:"min_wait_ms,omitempty"`
+	MaxWaitMs       int64      `json:"max_wait_ms,omitempty"`
+	TargetLatencyMs int64      `json:"target_latency_ms,omitempty"`
+	Decisions       []Decision `json:"decisions"`
+}
+
+const (
+	tunerInterval     = time.Second
+	tunerMaxDecisions = 50
+)
+
+// tuner adjusts batch size and wait time from observed write latency, batch
+// fill and queue depth, evaluated at most once per tunerInterval:
+//
+//   - queue >= 50% full: grow size x1.5, drop wait to the minimum (throughput)
+//   - latency above target: shrink size x0.75 (the database is struggling)
+//   - batches mostly cut by the timer while the queue is idle: wait x1.5
+//     (fewer, fuller round-trips at night)
+//   - full batches with plenty of latency headroom: grow size x1.25, wait x0.75
+type tuner struct {
+	mu     sync.Mutex
+	bounds BatchBounds
+	size   int
+	wait   time.Duration
+
+	winBatches  int
+	winLatency  time.Duration
+	winFill     float64
+	winQueueMax float64
+	lastEval    time.Time
+
+	decisions []Decision
+	now       func() time.Time
+}
+
+func newTuner(b BatchBounds, size int, wait time.Duration) *tuner {
+	t := &tuner{bounds: b, size: size, wait: wait, now: time.Now}
+	if b.Adaptive {
+		t.size = clampInt(size, b.MinSize, b.MaxSize)
+		t.wait = clampDur(wait, b.MinWait, b.MaxWait)
+	}
+	t.lastEval = t.now()
+	return t
+}
+
+func (t *tuner) current() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size, t.wait
+}
+
+// observe records one finished batch. target is the size the batch was cut
+// against, queueFill the queue occupancy (0..1) when it finished.
+func (t *tuner) observe(size, target int, latency time.Duration, queueFill float64) {
+	if !t.bounds.Adaptive {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.winBatches++
+	t.winLatency += latency
+	if target > 0 {
+		t.winFill += float64(size) / float64(target)
+	}
+	if queueFill > t.winQueueMax {
+		t.winQueueMax = queueFill
+	}
+	now := t.now()
+	if now.Sub(t.lastEval) < tunerInterval {
+		return
+	}
+	t.evaluateLocked(now)
+}
+
+func (t *tuner) evaluateLocked(now time.Time) {
+	b := t.bounds
+	avgLat := t.winLatency / time.Duration(t.winBatches)
+	fill := t.winFill / float64(t.winBatches)
+	queue := t.winQueueMax
+	t.winBatches, t.winLatency, t.winFill, t.winQueueMax = 0, 0, 0, 0
+	t.lastEval = now
+
+	size, wait := t.size, t.wait
+	var reason string
+	switch {
+	case queue >= 0.5:
+		size = clampInt(size*3/2, b.MinSize, b.MaxSize)
+		wait = b.MinWait
+		reason = fmt.Sprintf("queue %.0f%% full", queue*100)
+	case avgLat > b.TargetLatency:
+		size = clampInt(size*3/4, b.MinSize, b.MaxSize)
+		reason = fmt.Sprintf("write latency %s above target %s", avgLat.Round(time.Millisecond), b.TargetLatency)
+	case fill < 0.25 && queue < 0.1:
+		wait = clampDur(wait*3/2, b.MinWait, b.MaxWait)
+		reason = fmt.Sprintf("small batches (%.0f%% full) on an idle queue", fill*100)
+	case fill >= 0.9 && avgLat < b.TargetLatency/2:
+		size = clampInt(size+size/4, b.MinSize, b.MaxSize)
+		wait = clampDur(wait*3/4, b.MinWait, b.MaxWait)
+		reason = fmt.Sprintf("full batches with latency headroom (%s)", avgLat.Round(time.Millisecond))
+	}
+	if size == t.size && wait == t.wait {
+		return
+	}
+	t.size, t.wait = size, wait
+	t.decisions = append(t.decisions, Decision{At: now.UTC(), Size: size, WaitMs: wait.Milliseconds(), Reason: reason})
+	if len(t.decisions) > tunerMaxDecisions {
+		t.decisions = t.decisions[len(t.decisions)-tunerMaxDecisions:]
+	}
+}
+
+func (t *tuner) stats() BatchingStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := BatchingStats{
+		Adaptive:  t.bounds.Adaptive,
+		Size:      t.size,
+		WaitMs:    t.wait.Milliseconds(),
+		Decisions: append([]Decision{}, t.decisions...),
+	}
+	if t.bounds.Adaptive {
+		st.MinSize, st.MaxSize = t.bounds.MinSize, t.bounds.MaxSize
+		st.MinWaitMs, st.MaxWaitMs = t.bounds.MinWait.Milliseconds(), t.bounds.MaxWait.Milliseconds()
+		st.TargetLatencyMs = t.bounds.TargetLatency.Milliseconds()
+	}
+	return st
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func clampDur(v, lo, hi time.Duration) time.Duration {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,206 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testBounds = BatchBounds{
+	Adaptive:      true,
+	MinSize:       10,
+	MaxSize:       1000,
+	MinWait:       5 * time.Millisecond,
+	MaxWait:       200 * time.Millisecond,
+	TargetLatency: 100 * time.Millisecond,
+}
+
+// fakeClock is advanced by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestTuner(b BatchBounds, size int, wait time.Duration) (*tuner, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tu := newTuner(b, size, wait)
+	tu.now = clock.now
+	tu.lastEval = clock.now()
+	return tu, clock
+}
+
+func TestTunerReactions(t *testing.T) {
+	type batch struct {
+		size      int
+		latency   time.Duration
+		queueFill float64
+	}
+	tests := []struct {
+		name       string
+		size       int
+		wait       time.Duration
+		batches    []batch // observed within one interval; cut against size
+		wantSize   int
+		wantWait   time.Duration
+		wantReason string // empty: no decision
+	}{
+		{
+			name: "queue half full grows size and drops wait", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{100, 20 * time.Millisecond, 0.5}},
+			wantSize: 150, wantWait: 5 * time.Millisecond, wantReason: "queue 50% full",
+		},
+		{
+			name: "queue growth is capped at max size", size: 900, wait: 50 * time.Millisecond,
+			batches:  []batch{{900, 20 * time.Millisecond, 0.9}},
+			wantSize: 1000, wantWait: 5 * time.Millisecond, wantReason: "queue 90% full",
+		},
+		{
+			name: "queue pressure wins over slow writes", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{100, 300 * time.Millisecond, 0.6}},
+			wantSize: 150, wantWait: 5 * time.Millisecond, wantReason: "queue 60% full",
+		},
+		{
+			name: "slow writes shrink size", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{100, 150 * time.Millisecond, 0}},
+			wantSize: 75, wantWait: 50 * time.Millisecond, wantReason: "write latency 150ms above target 100ms",
+		},
+		{
+			name: "shrinking stops at min size", size: 12, wait: 50 * time.Millisecond,
+			batches:  []batch{{12, 150 * time.Millisecond, 0}},
+			wantSize: 10, wantWait: 50 * time.Millisecond, wantReason: "above target",
+		},
+		{
+			name: "latency is averaged over the window", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{50, 170 * time.Millisecond, 0.2}, {50, 10 * time.Millisecond, 0.2}},
+			wantSize: 100, wantWait: 50 * time.Millisecond,
+		},
+		{
+			name: "small batches on an idle queue wait longer", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{10, 10 * time.Millisecond, 0.05}},
+			wantSize: 100, wantWait: 75 * time.Millisecond, wantReason: "small batches (10% full)",
+		},
+		{
+			name: "waiting longer stops at max wait", size: 100, wait: 180 * time.Millisecond,
+			batches:  []batch{{1, 10 * time.Millisecond, 0}},
+			wantSize: 100, wantWait: 200 * time.Millisecond, wantReason: "small batches",
+		},
+		{
+			name: "small batches on a busy queue keep the wait", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{10, 10 * time.Millisecond, 0.3}},
+			wantSize: 100, wantWait: 50 * time.Millisecond,
+		},
+		{
+			name: "full fast batches grow size and cut wait", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{100, 20 * time.Millisecond, 0.2}, {95, 30 * time.Millisecond, 0.2}},
+			wantSize: 125, wantWait: 37500 * time.Microsecond, wantReason: "full batches with latency headroom (25ms)",
+		},
+		{
+			name: "full batches without headroom stay put", size: 100, wait: 50 * time.Millisecond,
+			batches:  []batch{{100, 60 * time.Millisecond, 0.2}},
+			wantSize: 100, wantWait: 50 * time.Millisecond,
+		},
+		{
+			name: "at the limits nothing changes", size: 1000, wait: 5 * time.Millisecond,
+			batches:  []batch{{1000, 10 * time.Millisecond, 0.2}},
+			wantSize: 1000, wantWait: 5 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tu, clock := newTestTuner(testBounds, tt.size, tt.wait)
+			for i, b := range tt.batches {
+				if i == len(tt.batches)-1 {
+					clock.advance(tunerInterval)
+				}
+				tu.observe(b.size, tt.size, b.latency, b.queueFill)
+			}
+			size, wait := tu.current()
+			if size != tt.wantSize || wait != tt.wantWait {
+				t.Fatalf("size, wait = %d, %s; want %d, %s", size, wait, tt.wantSize, tt.wantWait)
+			}
+			decisions := tu.stats().Decisions
+			if tt.wantReason == "" {
+				if len(decisions) != 0 {
+					t.Fatalf("decisions = %+v, want none", decisions)
+				}
+				return
+			}
+			if len(decisions) != 1 {
+				t.Fatalf("decisions = %+v, want one", decisions)
+			}
+			d := decisions[0]
+			if !strings.Contains(d.Reason, tt.wantReason) || d.Size != size || d.WaitMs != wait.Milliseconds() || !d.At.Equal(clock.now()) {
+				t.Fatalf("decision = %+v, want %q at %s", d, tt.wantReason, clock.now())
+			}
+		})
+	}
+}
+
+func TestTunerEvaluatesOncePerInterval(t *testing.T) {
+	tu, clock := newTestTuner(testBounds, 100, 50*time.Millisecond)
+
+	clock.advance(tunerInterval / 2)
+	tu.observe(100, 100, 20*time.Millisecond, 0.8)
+	if size, _ := tu.current(); size != 100 {
+		t.Fatalf("size = %d before the interval elapsed, want 100", size)
+	}
+
+	// the queue peak earlier in the window still counts
+	clock.advance(tunerInterval / 2)
+	tu.observe(100, 100, 20*time.Millisecond, 0)
+	if size, _ := tu.current(); size != 150 {
+		t.Fatalf("size = %d after the interval, want 150", size)
+	}
+
+	// a new window starts empty
+	clock.advance(tunerInterval / 2)
+	tu.observe(150, 150, 20*time.Millisecond, 0.8)
+	if size, _ := tu.current(); size != 150 {
+		t.Fatalf("size = %d right after a decision, want 150", size)
+	}
+}
+
+func TestTunerKeepsLastDecisions(t *testing.T) {
+	tu, clock := newTestTuner(testBounds, 100, 50*time.Millisecond)
+	// alternate pressure and slow writes so every evaluation changes the size
+	for i := range tunerMaxDecisions + 10 {
+		clock.advance(tunerInterval)
+		if i%2 == 0 {
+			tu.observe(100, 100, 20*time.Millisecond, 0.9)
+		} else {
+			tu.observe(100, 100, 300*time.Millisecond, 0)
+		}
+	}
+	st := tu.stats()
+	if len(st.Decisions) != tunerMaxDecisions {
+		t.Fatalf("kept %d decisions, want %d", len(st.Decisions), tunerMaxDecisions)
+	}
+	if last := st.Decisions[len(st.Decisions)-1]; !last.At.Equal(clock.now()) || last.Size != st.Size {
+		t.Fatalf("last decision = %+v, want the latest (size %d at %s)", last, st.Size, clock.now())
+	}
+}
+
+func TestTunerBounds(t *testing.T) {
+	t.Run("initial values are clamped", func(t *testing.T) {
+		tu, _ := newTestTuner(testBounds, 5000, time.Second)
+		if size, wait := tu.current(); size != 1000 || wait != 200*time.Millisecond {
+			t.Fatalf("size, wait = %d, %s; want 1000, 200ms", size, wait)
+		}
+		st := tu.stats()
+		if !st.Adaptive || st.MinSize != 10 || st.MaxSize != 1000 || st.MinWaitMs != 5 || st.MaxWaitMs != 200 || st.TargetLatencyMs != 100 {
+			t.Fatalf("stats = %+v, want the configured bounds", st)
+		}
+	})
+
+	t.Run("fixed batching ignores observations", func(t *testing.T) {
+		tu, clock := newTestTuner(BatchBounds{}, 5000, time.Second)
+		clock.advance(tunerInterval)
+		tu.observe(5000, 5000, time.Minute, 1)
+		if size, wait := tu.current(); size != 5000 || wait != time.Second {
+			t.Fatalf("size, wait = %d, %s; want the configured 5000, 1s", size, wait)
+		}
+		if st := tu.stats(); st.Adaptive || st.MaxSize != 0 || len(st.Decisions) != 0 {
+			t.Fatalf("stats = %+v, want fixed batching without bounds or decisions", st)
+		}
+	})
+}
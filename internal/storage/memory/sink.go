@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/idempotency"
+)
+
+// ErrRejected marks a permanent (data) error. Any other error a Fault returns
+// is transient.
+var ErrRejected = errors.New("memory: event rejected")
+
+// Sink keeps events in memory, deduplicated by idempotency key.
+// Useful for local runs and for exercising the ingestor without a database.
+type Sink struct {
+	// Fault, if set, is called before every write with its context and batch;
+	// an error fails the whole write and nothing of it is stored. Wrap
+	// ErrRejected to make the failure permanent. Set it before the sink is in use.
+	Fault func(ctx context.Context, events []domain.Event) error
+
+	mu     sync.Mutex
+	seen   map[string]struct{}
+	events []domain.Event
+	dead   []domain.DeadLetter
+	lastID int64
+}
+
+func NewSink() *Sink { return &Sink{seen: make(map[string]struct{})} }
+
+func (s *Sink) Name() string { return "memory" }
+
+func (s *Sink) Write(ctx context.Context, events []domain.Event) ([]bool, error) {
+	if s.Fault != nil {
+		if err := s.Fault(ctx, events); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inserted := make([]bool, len(events))
+	for i := range events {
+		key, src := idempotency.DeriveKey(&events[i])
+		k := string(src) + ":" + key
+		if _, dup := s.seen[k]; dup {
+			continue
+		}
+		s.seen[k] = struct{}{}
+		s.events = append(s.events, events[i])
+		inserted[i] = true
+	}
+	return inserted, nil
+}
+
+func (s *Sink) IsTransient(err error) bool { return !errors.Is(err, ErrRejected) }
+
+// DeadLetter parks an event.
+func (s *Sink) DeadLetter(ctx context.Context, ev domain.Event, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	s.dead = append(s.dead, domain.DeadLetter{ID: s.lastID, Event: ev, Error: reason, CreatedAt: time.Now().UTC()})
+	return nil
+}
+
+// Events returns a copy of everything stored so far, in write order.
+func (s *Sink) Events() []domain.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]domain.Event(nil), s.events...)
+}
+
+// DeadLetters returns a copy of the parked events, oldest first.
+func (s *Sink) DeadLetters() []domain.DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]domain.DeadLetter(nil), s.dead...)
+}
+
+// TakeDeadLetters removes the given parked events and returns those that existed.
+func (s *Sink) TakeDeadLetters(ctx context.Context, ids []int64) ([]domain.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var taken []domain.DeadLetter
+	s.dead = slices.DeleteFunc(s.dead, func(dl domain.DeadLetter) bool {
+		if slices.Contains(ids, dl.ID) {
+			taken = append(taken, dl)
+			return true
+		}
+		return false
+	})
+	return taken, nil
+}
+
+// RestoreDeadLetters parks taken events again under their original ids.
+func (s *Sink) RestoreDeadLetters(ctx context.Context, dls []domain.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dl := range dls {
+		if !slices.ContainsFunc(s.dead, func(p domain.DeadLetter) bool { return p.ID == dl.ID }) {
+			s.dead = append(s.dead, dl)
+		}
+	}
+	slices.SortFunc(s.dead, func(a, b domain.DeadLetter) int { return cmp.Compare(a.ID, b.ID) })
+	return nil
+}
@@ -0,0 +1,100 @@
+package ndjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+)
+
+// Sink appends events as newline-delimited JSON to rolling files in a
+// directory (events-<UTC timestamp>.ndjson). A new file is started on open
+// and whenever the current one exceeds maxBytes. Every batch is fsynced.
+//
+// The sink cannot detect duplicates: replays after a crash may repeat lines,
+// so consumers should deduplicate on event_id or the composite key.
+type Sink struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	f        *os.File
+	size     int64
+}
+
+func Open(dir string, maxBytes int64) (*Sink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("ndjson mkdir: %w", err)
+	}
+	s := &Sink{dir: dir, maxBytes: maxBytes}
+	if err := s.rotateLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Sink) Name() string { return "ndjson" }
+
+func (s *Sink) Write(ctx context.Context, events []domain.Event) ([]bool, error) {
+	var buf []byte
+	for i := range events {
+		b, err := json.Marshal(events[i])
+		if err != nil {
+			return nil, fmt.Errorf("ndjson encode: %w", err)
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.size >= s.maxBytes {
+		if err := s.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.f.Write(buf); err != nil {
+		return nil, fmt.Errorf("ndjson write: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return nil, fmt.Errorf("ndjson fsync: %w", err)
+	}
+	s.size += int64(len(buf))
+
+	inserted := make([]bool, len(events))
+	for i := range inserted {
+		inserted[i] = true
+	}
+	return inserted, nil
+}
+
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *Sink) rotateLocked() error {
+	if s.f != nil {
+		if err := s.f.Close(); err != nil {
+			return fmt.Errorf("ndjson close: %w", err)
+		}
+	}
+	name := "events-" + time.Now().UTC().Format("20060102T150405.000000000Z") + ".ndjson"
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("ndjson open: %w", err)
+	}
+	s.f = f
+	s.size = 0
+	return nil
+}
@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Rolling windows of the active-user counts, in days including the day itself.
+const (
+	WeeklyActiveDays  = 7
+	MonthlyActiveDays = 30
+)
+
+// ActiveUsersDay holds the distinct users active on Day (YYYY-MM-DD in the
+// query zone) and in the 7 and 30 days ending on it.
+type ActiveUsersDay struct {
+	Day   string
+	Start int64 // local midnight of Day, epoch seconds
+	DAU   int64
+	WAU   int64
+	MAU   int64
+}
+
+// QueryActiveUsers reports rolling active users for every local day from
+// the day of q.From to the day of q.To, matching events by q.EventName,
+// q.Channel and q.Filters. The windows of the first days reach back up to
+// 29 days before q.From. Always reads raw events.
+func (db *DB) QueryActiveUsers(ctx context.Context, q MetricsQuery) ([]ActiveUsersDay, error) {
+	defer observeQuery("active_users", time.Now())
+	loc := q.loc()
+	first := time.Unix(q.From, 0).In(loc)
+	last := time.Unix(q.To, 0).In(loc)
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	last = time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, loc)
+
+	wq := q
+	wq.From = first.AddDate(0, 0, -(MonthlyActiveDays - 1)).Unix()
+	wq.To = last.AddDate(0, 0, 1).Unix() - 1
+	cond, args := wq.where("ts_epoch")
+	args = append(args, loc.String(), first.Format(time.DateOnly), last.Format(time.DateOnly))
+	tz := len(args) - 2
+
+	// each user counts once per report day, with the latest active day of
+	// the 30-day window: that day decides the 1- and 7-day windows too
+	sql := fmt.Sprintf(`
+WITH ud AS (
+  SELECT DISTINCT user_id, (to_timestamp(ts_epoch) AT TIME ZONE $%[2]d)::date AS day
+  FROM events %[1]s
+), days AS (
+  SELECT generate_series($%[3]d::date, $%[4]d::date, interval '1 day')::date AS day
+), seen AS (
+  SELECT d.day, MAX(ud.day) AS last_day
+  FROM days d JOIN ud ON ud.day BETWEEN d.day - %[5]d AND d.day
+  GROUP BY d.day, ud.user_id
+)
+SELECT to_char(d.day, 'YYYY-MM-DD'),
+       COUNT(*) FILTER (WHERE s.last_day = d.day),
+       COUNT(*) FILTER (WHERE s.last_day > d.day - %[6]d),
+       COUNT(s.last_day)
+FROM days d LEFT JOIN seen s ON s.day = d.day
+GROUP BY d.day
+ORDER BY d.day`, cond, tz, tz+1, tz+2, MonthlyActiveDays-1, WeeklyActiveDays)
+
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ActiveUsersDay
+	for rows.Next() {
+		var d ActiveUsersDay
+		if err := rows.Scan(&d.Day, &d.DAU, &d.WAU, &d.MAU); err != nil {
+			return nil, fmt.Errorf("scan active users: %w", err)
+		}
+		t, err := time.ParseInLocation(time.DateOnly, d.Day, loc)
+		if err != nil {
+			return nil, fmt.Errorf("parse active users day %q: %w", d.Day, err)
+		}
+		d.Start = t.Unix()
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CohortQuery groups users by the bucket of their first ever CohortEvent and
+// counts who performed ReturnEvent in each following period.
+type CohortQuery struct {
+	CohortEvent string
+	ReturnEvent string
+	Granularity Granularity    // cohort width: day, week or month
+	Period      Granularity    // period width: day, week or month
+	Periods     int            // last period reported; period 0 is the first one
+	From, To    int64          // first CohortEvent in [From, To], epoch seconds
+	TZ          *time.Location // cohort and period boundaries; nil = UTC
+}
+
+// Cohort is one row of the retention matrix. Returned[k] is the number of
+// users with a ReturnEvent k periods after their own first CohortEvent
+// (calendar days, 7-day weeks or calendar months in TZ, counted from the
+// user's first day); period 0 counts return events from the first event on.
+type Cohort struct {
+	Start    int64
+	Users    int64
+	Returned []int64
+}
+
+func (q CohortQuery) loc() *time.Location {
+	if q.TZ == nil {
+		return time.UTC
+	}
+	return q.TZ
+}
+
+// Aligned widens [From, To] to whole cohort buckets of Granularity in TZ, so
+// that every reported cohort is complete and equivalent requests share one
+// cache entry.
+func (q CohortQuery) Aligned() CohortQuery {
+	loc := q.loc()
+	q.From = q.Granularity.BucketStart(q.From, loc)
+	q.To = q.Granularity.next(q.Granularity.BucketStart(q.To, loc), loc) - 1
+	return q
+}
+
+// periodExpr is the number of whole periods of g between the local dates d0
+// and d1.
+func (g Granularity) periodExpr(d0, d1 string) string {
+	switch g {
+	case GranularityWeek:
+		return fmt.Sprintf("((%s) - (%s)) / 7", d1, d0)
+	case GranularityMonth:
+		return fmt.Sprintf("((EXTRACT(YEAR FROM %[2]s) - EXTRACT(YEAR FROM %[1]s)) * 12 + EXTRACT(MONTH FROM %[2]s) - EXTRACT(MONTH FROM %[1]s))::int", d0, d1)
+	}
+	return fmt.Sprintf("(%s) - (%s)", d1, d0)
+}
+
+// QueryCohorts computes the retention matrix for q, oldest cohort first.
+// Only cohort events in [From, To] are scanned; a user whose earliest one
+// there is preceded by an older cohort event (an index probe per user) is
+// not new and is left out. Return events are read up to Periods periods
+// after To.
+func (db *DB) QueryCohorts(ctx context.Context, q CohortQuery) ([]Cohort, error) {
+	defer observeQuery("cohorts", time.Now())
+	// a month is at most 31 days; one extra period of slack for offsets
+	horizon := int64(q.Periods+2) * q.Period.Span()
+	if q.Period == GranularityMonth {
+		horizon = int64(q.Periods+2) * 31 * 86400
+	}
+
+	sql := fmt.Sprintf(`
+WITH firsts AS (
+  SELECT f.user_id, MIN(f.ts_epoch) AS t0
+  FROM events f
+  WHERE f.event_name = $1 AND f.ts_epoch BETWEEN $2 AND $3
+    AND NOT EXISTS (
+      SELECT 1 FROM events p
+      WHERE p.event_name = $1 AND p.user_id = f.user_id AND p.ts_epoch < $2)
+  GROUP BY f.user_id
+), cohorts AS (
+  SELECT user_id, t0, %[1]s AS cohort, (to_timestamp(t0) AT TIME ZONE $4)::date AS d0
+  FROM firsts
+), returns AS (
+  SELECT DISTINCT c.user_id, c.cohort,
+         %[2]s AS period
+  FROM cohorts c
+  JOIN events e ON e.user_id = c.user_id AND e.ts_epoch >= c.t0
+  WHERE e.event_name = $5 AND e.ts_epoch BETWEEN $2 AND $3 + $6
+)
+SELECT cohort, -1, COUNT(*) FROM cohorts GROUP BY cohort
+UNION ALL
+SELECT cohort, period, COUNT(*) FROM returns WHERE period <= $7 GROUP BY cohort, period`,
+		q.Granularity.bucketExpr("t0", "$4"),
+		q.Period.periodExpr("c.d0", "(to_timestamp(e.ts_epoch) AT TIME ZONE $4)::date"))
+
+	rows, err := db.Pool.Query(ctx, sql, q.CohortEvent, q.From, q.To, q.loc().String(), q.ReturnEvent, horizon, q.Periods)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byStart := map[int64]*Cohort{}
+	for rows.Next() {
+		var (
+			start  int64
+			period int
+			users  int64
+		)
+		if err := rows.Scan(&start, &period, &users); err != nil {
+			return nil, fmt.Errorf("scan cohort: %w", err)
+		}
+		c, ok := byStart[start]
+		if !ok {
+			c = &Cohort{Start: start, Returned: make([]int64, q.Periods+1)}
+			byStart[start] = c
+		}
+		if period < 0 {
+			c.Users = users
+		} else {
+			c.Returned[period] = users
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]Cohort, 0, len(byStart))
+	for _, c := range byStart {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out, nil
+}
+
+// PeriodStarted reports whether period k can have begun by now for any user
+// of cohort c, i.e. for users whose first event was at the cohort start.
+func (q CohortQuery) PeriodStarted(c Cohort, k int, now int64) bool {
+	loc := q.loc()
+	t := time.Unix(c.Start, 0).In(loc)
+	switch q.Period {
+	case GranularityWeek:
+		t = t.AddDate(0, 0, 7*k)
+	case GranularityMonth:
+		t = time.Date(t.Year(), t.Month()+time.Month(k), 1, 0, 0, 0, 0, loc)
+	default:
+		t = t.AddDate(0, 0, k)
+	}
+	return t.Unix() <= now
+}
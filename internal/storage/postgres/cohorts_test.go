@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCohortAligned(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	at := func(y int, m time.Month, d, h int) int64 { return time.Date(y, m, d, h, 0, 0, 0, berlin).Unix() }
+	tests := []struct {
+		g                Granularity
+		from, to         int64
+		wantFrom, wantTo int64
+	}{
+		// Wednesday to the Tuesday of the DST week: Monday to the following Sunday night
+		{GranularityWeek, at(2024, 10, 23, 15), at(2024, 10, 29, 9), at(2024, 10, 21, 0), at(2024, 11, 4, 0) - 1},
+		{GranularityDay, at(2024, 10, 27, 2), at(2024, 10, 27, 23), at(2024, 10, 27, 0), at(2024, 10, 28, 0) - 1},
+		{GranularityMonth, at(2024, 1, 31, 12), at(2024, 2, 1, 0), at(2024, 1, 1, 0), at(2024, 3, 1, 0) - 1},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.g), func(t *testing.T) {
+			q := CohortQuery{Granularity: tc.g, From: tc.from, To: tc.to, TZ: berlin}.Aligned()
+			if q.From != tc.wantFrom || q.To != tc.wantTo {
+				t.Fatalf("aligned to [%d, %d], want [%d, %d]", q.From, q.To, tc.wantFrom, tc.wantTo)
+			}
+			// requests inside the same buckets share one cache key
+			if again := q.Aligned(); again != q {
+				t.Fatal("Aligned is not idempotent")
+			}
+		})
+	}
+}
@@ -3,8 +3,6 @@ package postgres
 import (
 	"context"
 	"fmt"
-	"io"
-	"os"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -22,7 +20,9 @@ func Connect(ctx context.Context, dsn string) (*DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("pgxpool: %w", err)
 	}
-	return &DB{Pool: pool}, nil
+	db := &DB{Pool: pool}
+	db.registerPoolGauges()
+	return db, nil
 }
 
 func (db *DB) Close() {
@@ -35,21 +35,3 @@ func (db *DB) Ready(ctx context.Context) error {
 	var one int
 	return db.Pool.QueryRow(ctx, "select 1").Scan(&one)
 }
-
-// RunMigration executes a single SQL file (MVP) to keep dependencies zero.
-func (db *DB) RunMigration(ctx context.Context, path string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("open migration: %w", err)
-	}
-	defer f.Close()
-	sqlBytes, err := io.ReadAll(f)
-	if err != nil {
-		return fmt.Errorf("read migration: %w", err)
-	}
-	_, err = db.Pool.Exec(ctx, string(sqlBytes))
-	if err != nil {
-		return fmt.Errorf("exec migration: %w", err)
-	}
-	return nil
-}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"example.com/goAssignment1/internal/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+// DeadLetter parks an event with the error that rejected it.
+// The payload is stored as TEXT so that events whose JSON Postgres refuses
+// as JSONB (the usual poison) can still be kept.
+func (w *Writer) DeadLetter(ctx context.Context, ev domain.Event, reason string) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("encode dead letter: %w", err)
+	}
+	_, err = w.db.Pool.Exec(ctx,
+		"INSERT INTO events_dead_letter (payload, error) VALUES ($1, $2)", string(b), reason)
+	return err
+}
+
+// ListDeadLetters returns up to limit entries with id > afterID, oldest first.
+func (db *DB) ListDeadLetters(ctx context.Context, afterID int64, limit int) ([]domain.DeadLetter, error) {
+	rows, err := db.Pool.Query(ctx, `
+SELECT id, payload, error, created_at
+FROM events_dead_letter
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeadLetters(rows)
+}
+
+// TakeDeadLetters removes the given entries and returns those that existed,
+// once the delete has committed (see ingest.Ingestor.RedriveDeadLetters).
+func (db *DB) TakeDeadLetters(ctx context.Context, ids []int64) ([]domain.DeadLetter, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+DELETE FROM events_dead_letter
+WHERE id = ANY($1)
+RETURNING id, payload, error, created_at`, ids)
+	if err != nil {
+		return nil, err
+	}
+	// an entry that cannot be decoded rolls the whole delete back
+	dls, err := scanDeadLetters(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return dls, nil
+}
+
+// RestoreDeadLetters parks entries removed by TakeDeadLetters again under
+// their original ids.
+func (db *DB) RestoreDeadLetters(ctx context.Context, dls []domain.DeadLetter) error {
+	ids := make([]int64, len(dls))
+	payloads := make([]string, len(dls))
+	reasons := make([]string, len(dls))
+	created := make([]time.Time, len(dls))
+	for i, dl := range dls {
+		b, err := json.Marshal(dl.Event)
+		if err != nil {
+			return fmt.Errorf("encode dead letter %d: %w", dl.ID, err)
+		}
+		ids[i], payloads[i], reasons[i], created[i] = dl.ID, string(b), dl.Error, dl.CreatedAt
+	}
+	_, err := db.Pool.Exec(ctx, `
+INSERT INTO events_dead_letter (id, payload, error, created_at)
+SELECT * FROM unnest($1::bigint[], $2::text[], $3::text[], $4::timestamptz[])
+ON CONFLICT (id) DO NOTHING`, ids, payloads, reasons, created)
+	return err
+}
+
+func scanDeadLetters(rows pgx.Rows) ([]domain.DeadLetter, error) {
+	var out []domain.DeadLetter
+	for rows.Next() {
+		var (
+			dl      domain.DeadLetter
+			payload string
+		)
+		if err := rows.Scan(&dl.ID, &payload, &dl.Error, &dl.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan dead letter: %w", err)
+		}
+		if err := json.Unmarshal([]byte(payload), &dl.Event); err != nil {
+			return nil, fmt.Errorf("decode dead letter %d: %w", dl.ID, err)
+		}
+		out = append(out, dl)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// IsTransient reports whether a write error is worth retrying as-is.
+// Server-reported data/constraint/syntax errors are permanent: the same rows
+// will fail again. Connection, resource and concurrency errors (and anything
+// that never reached the server) are transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return true
+	}
+	if len(pgErr.Code) < 2 {
+		return false
+	}
+	switch pgErr.Code[:2] {
+	case "08", // connection exception
+		"40", // transaction rollback (serialization failure, deadlock)
+		"53", // insufficient resources
+		"57", // operator intervention (admin shutdown, query canceled)
+		"58": // system error
+		return true
+	}
+	return pgErr.Code == "55P03" // lock_not_available
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FunnelStep selects the events that complete one step.
+type FunnelStep struct {
+	EventName string
+	Channel   string   // optional
+	Filters   []Filter // optional tag/metadata conditions
+}
+
+// FunnelQuery is an ordered funnel: users enter with their first step-1 event
+// in [From, To] and complete each later step with its first matching event
+// at or after the previous step, within Window seconds of entering.
+type FunnelQuery struct {
+	Steps    []FunnelStep
+	From, To int64 // inclusive epoch seconds, for the first step
+	Window   int64 // conversion window in seconds
+}
+
+// FunnelStepResult is how many users reached a step and the median time
+// they took from the previous step (nil for the first step or no users).
+type FunnelStepResult struct {
+	Users                     int64
+	MedianSecondsFromPrevious *float64
+}
+
+// QueryFunnel evaluates q over the events table, one CTE per step.
+func (db *DB) QueryFunnel(ctx context.Context, q FunnelQuery) ([]FunnelStepResult, error) {
+	defer observeQuery("funnel", time.Now())
+
+	args := []any{q.From, q.To, q.Window}
+	stepCond := func(st FunnelStep) string {
+		args = append(args, st.EventName)
+		cond := fmt.Sprintf("e.event_name = $%d", len(args))
+		if st.Channel != "" {
+			args = append(args, st.Channel)
+			cond += fmt.Sprintf(" AND e.channel = $%d", len(args))
+		}
+		for _, f := range st.Filters {
+			var c string
+			c, args = f.sql(args)
+			cond += " AND " + c
+		}
+		return cond
+	}
+
+	var ctes, results []string
+	for i, st := range q.Steps {
+		name := fmt.Sprintf("s%d", i+1)
+		if i == 0 {
+			ctes = append(ctes, fmt.Sprintf(`%s AS (
+  SELECT e.user_id, MIN(e.ts_epoch) AS t0, MIN(e.ts_epoch) AS t, NULL::bigint AS dt
+  FROM events e
+  WHERE e.ts_epoch BETWEEN $1 AND $2 AND %s
+  GROUP BY e.user_id
+)`, name, stepCond(st)))
+			results = append(results, "SELECT 1 AS step, COUNT(*), NULL::float8 FROM s1")
+			continue
+		}
+		// the same event must not complete two consecutive steps
+		after := ">="
+		if st.EventName == q.Steps[i-1].EventName {
+			after = ">"
+		}
+		ctes = append(ctes, fmt.Sprintf(`%s AS (
+  SELECT p.user_id, p.t0, MIN(e.ts_epoch) AS t, MIN(e.ts_epoch) - p.t AS dt
+  FROM s%d p
+  JOIN events e ON e.user_id = p.user_id AND e.ts_epoch %s p.t AND e.ts_epoch <= p.t0 + $3
+  WHERE e.ts_epoch BETWEEN $1 AND $2 + $3 AND %s
+  GROUP BY p.user_id, p.t0, p.t
+)`, name, i, after, stepCond(st)))
+		results = append(results, fmt.Sprintf(
+			"SELECT %d, COUNT(*), percentile_cont(0.5) WITHIN GROUP (ORDER BY dt::float8) FROM %s", i+1, name))
+	}
+	sql := "WITH " + strings.Join(ctes, ",\n") + "\n" + strings.Join(results, "\nUNION ALL\n") + "\nORDER BY 1"
+
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]FunnelStepResult, 0, len(q.Steps))
+	for rows.Next() {
+		var (
+			step int
+			r    FunnelStepResult
+		)
+		if err := rows.Scan(&step, &r.Users, &r.MedianSecondsFromPrevious); err != nil {
+			return nil, fmt.Errorf("scan funnel step: %w", err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
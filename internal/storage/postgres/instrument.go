@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"time"
+
+	"example.com/goAssignment1/internal/telemetry"
+)
+
+var (
+	mInsertSeconds = telemetry.Default.NewHistogramVec("events_api_db_insert_duration_seconds",
+		"Duration of InsertBatch calls.", telemetry.LatencyBuckets, "mode")
+	mInsertRows = telemetry.Default.NewCounterVec("events_api_db_insert_rows_total",
+		"Rows sent by InsertBatch, by result (inserted, duplicate).", "result")
+	mInsertErrors = telemetry.Default.NewCounterVec("events_api_db_insert_errors_total",
+		"Failed InsertBatch calls by error class (transient, permanent).", "class")
+	mQuerySeconds = telemetry.Default.NewHistogramVec("events_api_db_query_duration_seconds",
+		"Duration of read queries.", telemetry.LatencyBuckets, "query")
+)
+
+// observeQuery records the duration of a named read query; use with defer.
+func observeQuery(name string, start time.Time) {
+	mQuerySeconds.With(name).Observe(time.Since(start).Seconds())
+}
+
+func observeInsert(mode InsertMode, start time.Time, inserted []bool, err error) {
+	mInsertSeconds.With(string(mode)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		class := "permanent"
+		if IsTransient(err) {
+			class = "transient"
+		}
+		mInsertErrors.With(class).Inc()
+		return
+	}
+	n := 0
+	for _, ok := range inserted {
+		if ok {
+			n++
+		}
+	}
+	mInsertRows.With("inserted").Add(float64(n))
+	mInsertRows.With("duplicate").Add(float64(len(inserted) - n))
+}
+
+func (db *DB) registerPoolGauges() {
+	r := telemetry.Default
+	r.SetGaugeFunc("events_api_db_pool_total_conns", "Connections in the pool.",
+		func() float64 { return float64(db.Pool.Stat().TotalConns()) })
+	r.SetGaugeFunc("events_api_db_pool_acquired_conns", "Connections currently checked out.",
+		func() float64 { return float64(db.Pool.Stat().AcquiredConns()) })
+	r.SetGaugeFunc("events_api_db_pool_idle_conns", "Idle connections in the pool.",
+		func() float64 { return float64(db.Pool.Stat().IdleConns()) })
+}
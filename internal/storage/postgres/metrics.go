@@ -3,69 +3,322 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"math"
+	"time"
 )
 
 type MetricsTotals struct {
-	Count       int64 `json:"count"`
-	UniqueUsers int64 `json:"unique_users"`
+	Count       int64    `json:"count"`
+	UniqueUsers int64    `json:"unique_users"`
+	Value       *float64 `json:"value,omitempty"` // MetricsQuery.Aggregate; nil without numeric values
 }
 
 type MetricsBucket struct {
-	BucketStart int64 `json:"bucket_start"`
-	Count       int64 `json:"count"`
-	UniqueUsers int64 `json:"unique_users"`
+	BucketStart int64    `json:"bucket_start"`
+	Count       int64    `json:"count"`
+	UniqueUsers int64    `json:"unique_users"`
+	Value       *float64 `json:"value,omitempty"`
 }
 
-// eventName and channel are optional (nil or empty string means "no filter")
-func (db *DB) QueryTotals(ctx context.Context, eventName *string, from, to int64, channel *string) (MetricsTotals, error) {
-	var res MetricsTotals
+// Granularity is the width of metrics buckets. Buckets follow the query's
+// time zone (MetricsQuery.TZ); weeks are ISO weeks starting on Monday.
+type Granularity string
 
-	cond := "WHERE ts_epoch >= $1 AND ts_epoch <= $2"
-	args := []any{from, to}
-	idx := 3
+const (
+	GranularityMinute Granularity = "minute"
+	GranularityHour   Granularity = "hour"
+	GranularityDay    Granularity = "day"
+	GranularityWeek   Granularity = "week"
+	GranularityMonth  Granularity = "month"
+)
 
-	if eventName != nil && *eventName != "" {
-		cond += fmt.Sprintf(" AND event_name=$%d", idx)
-		args = append(args, *eventName)
-		idx++
+// ParseGranularity validates a group_by value.
+func ParseGranularity(s string) (Granularity, bool) {
+	switch g := Granularity(s); g {
+	case GranularityMinute, GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+		return g, true
 	}
-	if channel != nil && *channel != "" {
-		cond += fmt.Sprintf(" AND channel=$%d", idx)
-		args = append(args, *channel)
+	return "", false
+}
+
+// Span is the (nominal, for week and month: shortest) bucket width in seconds.
+func (g Granularity) Span() int64 {
+	switch g {
+	case GranularityMinute:
+		return 60
+	case GranularityHour:
+		return 3600
+	case GranularityDay:
+		return 86400
+	case GranularityWeek:
+		return 7 * 86400
+	case GranularityMonth:
+		return 28 * 86400
 	}
+	return math.MaxInt64
+}
 
-	sql := "SELECT COUNT(*)::bigint, COUNT(DISTINCT user_id)::bigint FROM events " + cond
-	row := db.Pool.QueryRow(ctx, sql, args...)
-	if err := row.Scan(&res.Count, &res.UniqueUsers); err != nil {
-		return res, fmt.Errorf("scan totals: %w", err)
+// bucketExpr truncates the epoch-seconds column col to g in SQL, in the zone
+// named by the parameter tzArg (e.g. "$3"), exactly like BucketStart.
+// Minutes and hours are truncated in the UTC offset in force at col, so the
+// repeated hour of a DST fall-back stays two buckets (wall-clock truncation
+// would map both to one instant). Days and longer are wall-clock truncation in
+// the zone, converted back to an instant.
+func (g Granularity) bucketExpr(col, tzArg string) string {
+	switch g {
+	case GranularityMinute, GranularityHour:
+		off := fmt.Sprintf("EXTRACT(EPOCH FROM (to_timestamp(%[1]s) AT TIME ZONE %[2]s) - (to_timestamp(%[1]s) AT TIME ZONE 'UTC'))::bigint", col, tzArg)
+		return fmt.Sprintf("(%[1]s - ((%[1]s + %[2]s) %% %[3]d + %[3]d) %% %[3]d)", col, off, g.Span())
 	}
-	return res, nil
+	return fmt.Sprintf("EXTRACT(EPOCH FROM date_trunc('%s', to_timestamp(%s) AT TIME ZONE %s) AT TIME ZONE %s)::bigint", g, col, tzArg, tzArg)
+}
+
+// BucketStart truncates ts to g in loc, matching bucketExpr.
+func (g Granularity) BucketStart(ts int64, loc *time.Location) int64 {
+	t := time.Unix(ts, 0).In(loc)
+	switch g {
+	case GranularityMinute, GranularityHour:
+		// truncate in the offset in force at ts, so the repeated hour of a
+		// DST fall-back stays two buckets; matches bucketExpr
+		_, off := t.Zone()
+		local := ts + int64(off)
+		return floorDiv(local, g.Span())*g.Span() - int64(off)
+	case GranularityDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Unix()
+	case GranularityWeek:
+		back := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, loc).Unix()
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Unix()
+	}
+	return 0
+}
+
+// next returns the start of the bucket after the one starting at start.
+func (g Granularity) next(start int64, loc *time.Location) int64 {
+	t := time.Unix(start, 0).In(loc)
+	var n int64
+	switch g {
+	case GranularityDay:
+		n = g.BucketStart(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc).Unix(), loc)
+	case GranularityWeek:
+		n = g.BucketStart(time.Date(t.Year(), t.Month(), t.Day()+7, 0, 0, 0, 0, loc).Unix(), loc)
+	case GranularityMonth:
+		n = g.BucketStart(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc).Unix(), loc)
+	default:
+		n = g.BucketStart(start+g.Span(), loc)
+	}
+	if n <= start {
+		n = start + g.Span()
+	}
+	return n
+}
+
+// fillBuckets returns bs (sorted by start) with a zero bucket added for every
+// bucket of g overlapping [from, to] that has no events.
+func fillBuckets(bs []MetricsBucket, from, to int64, g Granularity, loc *time.Location) []MetricsBucket {
+	return fillSeries(bs, func(b MetricsBucket) int64 { return b.BucketStart },
+		func(start int64) MetricsBucket { return MetricsBucket{BucketStart: start} }, from, to, g, loc)
 }
 
-func (db *DB) QueryBucketsDaily(ctx context.Context, eventName *string, from, to int64, channel *string) ([]MetricsBucket, error) {
-	cond := "WHERE ts_epoch >= $1 AND ts_epoch <= $2"
-	args := []any{from, to}
-	idx := 3
+// fillSeries is fillBuckets for any bucket type: startOf reads a bucket's
+// start, empty makes the bucket for a start without data.
+func fillSeries[B any](bs []B, startOf func(B) int64, empty func(int64) B, from, to int64, g Granularity, loc *time.Location) []B {
+	out := make([]B, 0, len(bs))
+	i := 0
+	for start := g.BucketStart(from, loc); start <= to; start = g.next(start, loc) {
+		for i < len(bs) && startOf(bs[i]) < start {
+			out = append(out, bs[i])
+			i++
+		}
+		if i < len(bs) && startOf(bs[i]) == start {
+			out = append(out, bs[i])
+			i++
+			continue
+		}
+		out = append(out, empty(start))
+	}
+	return append(out, bs[i:]...)
+}
 
-	if eventName != nil && *eventName != "" {
-		cond += fmt.Sprintf(" AND event_name=$%d", idx)
-		args = append(args, *eventName)
-		idx++
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
 	}
-	if channel != nil && *channel != "" {
-		cond += fmt.Sprintf(" AND channel=$%d", idx)
-		args = append(args, *channel)
+	return q
+}
+
+// MetricsQuery selects the events a metrics query aggregates.
+type MetricsQuery struct {
+	EventName string         // optional, "" = no filter
+	Channel   string         // optional, "" = no filter
+	From, To  int64          // inclusive epoch seconds
+	TZ        *time.Location // bucket time zone; nil = UTC
+	Fill      bool           // return empty buckets as zeros
+	Filters   []Filter       // tag/metadata conditions, all must hold
+	Aggregate *Aggregate     // optional numeric aggregate over metadata
+}
+
+func (q MetricsQuery) loc() *time.Location {
+	if q.TZ == nil {
+		return time.UTC
 	}
+	return q.TZ
+}
 
-	sql := fmt.Sprintf(`
+// rollupUsable reports whether rg can answer q. Rollups carry no tags or
+// metadata, so filters and aggregates need the raw events. Rollup rows must
+// also be assignable whole to buckets in q's zone: every UTC offset in force
+// during [From, To] must be a multiple of the rollup width (daily rollups:
+// UTC-like zones only; hourly rollups: zones without half-hour offsets).
+func (q MetricsQuery) rollupUsable(rg rollupGrain) bool {
+	if len(q.Filters) > 0 || q.Aggregate != nil {
+		return false
+	}
+	loc := q.loc()
+	if loc == time.UTC {
+		return true
+	}
+	t := time.Unix(q.From, 0).In(loc)
+	for {
+		_, off := t.Zone()
+		if int64(off)%rg.span != 0 {
+			return false
+		}
+		_, end := t.ZoneBounds()
+		if end.IsZero() || end.Unix() > q.To {
+			return true
+		}
+		t = end
+	}
+}
+
+// rollupFor picks the coarsest rollup no wider than maxSpan that is usable in
+// q's zone and whose buckets exactly cover [From, To].
+func (q MetricsQuery) rollupFor(maxSpan int64) (rollupGrain, bool) {
+	for i := len(rollupGrains) - 1; i >= 0; i-- {
+		rg := rollupGrains[i]
+		if rg.span <= maxSpan && q.rollupUsable(rg) && aligned(q.From, q.To, rg) {
+			return rg, true
+		}
+	}
+	return rollupGrain{}, false
+}
+
+// MetricsSource names where a metrics query was answered from.
+type MetricsSource string
+
+const (
+	SourceRaw          MetricsSource = "raw"
+	SourceRollupHourly MetricsSource = "rollup_hourly"
+	SourceRollupDaily  MetricsSource = "rollup_daily"
+)
+
+// Source reports which table buckets of granularity g (totals: "") over q
+// read: the coarsest usable rollup no wider than g whose buckets exactly
+// cover [From, To], otherwise the raw events.
+func (q MetricsQuery) Source(g Granularity) MetricsSource {
+	rg, ok := q.rollupFor(g.Span())
+	if !ok {
+		return SourceRaw
+	}
+	return MetricsSource("rollup_" + rg.name)
+}
+
+// where builds the filter for a table with tsCol as its time column. Rollup
+// tables store a missing channel as an empty string, which never equals a
+// channel filter.
+func (q MetricsQuery) where(tsCol string) (string, []any) {
+	cond := fmt.Sprintf("WHERE %s >= $1 AND %s <= $2", tsCol, tsCol)
+	args := []any{q.From, q.To}
+	if q.EventName != "" {
+		args = append(args, q.EventName)
+		cond += fmt.Sprintf(" AND event_name=$%d", len(args))
+	}
+	if q.Channel != "" {
+		args = append(args, q.Channel)
+		cond += fmt.Sprintf(" AND channel=$%d", len(args))
+	}
+	for _, f := range q.Filters {
+		var c string
+		c, args = f.sql(args)
+		cond += " AND " + c
+	}
+	return cond, args
+}
+
+func (db *DB) QueryTotals(ctx context.Context, q MetricsQuery) (MetricsTotals, error) {
+	defer observeQuery("totals", time.Now())
+	var res MetricsTotals
+
+	var sql string
+	var args []any
+	if rg, ok := q.rollupFor(math.MaxInt64); ok {
+		// counts from the rollup; exact unique users need the raw rows
+		var cond string
+		cond, args = q.where("bucket_start")
+		rawCond, _ := q.where("ts_epoch")
+		sql = fmt.Sprintf(`SELECT
+  (SELECT COALESCE(SUM(count), 0)::bigint FROM %s %s),
+  (SELECT COUNT(DISTINCT user_id)::bigint FROM events %s),
+  NULL::float8`, rg.countsTable(), cond, rawCond)
+	} else {
+		var cond, value string
+		cond, args = q.where("ts_epoch")
+		value, args = q.aggregateSQL(args)
+		sql = "SELECT COUNT(*)::bigint, COUNT(DISTINCT user_id)::bigint, " + value + " FROM events " + cond
+	}
+
+	row := db.Pool.QueryRow(ctx, sql, args...)
+	if err := row.Scan(&res.Count, &res.UniqueUsers, &res.Value); err != nil {
+		return res, fmt.Errorf("scan totals: %w", err)
+	}
+	return res, nil
+}
+
+// QueryBuckets returns per-bucket counts and exact unique users at
+// granularity g: every bucket of [From, To] with q.Fill, otherwise only
+// buckets that have events.
+func (db *DB) QueryBuckets(ctx context.Context, q MetricsQuery, g Granularity) ([]MetricsBucket, error) {
+	defer observeQuery("buckets_"+string(g), time.Now())
+
+	var sql string
+	var args []any
+	if rg, ok := q.rollupFor(g.Span()); ok {
+		// counts from the rollup; exact unique users need the raw rows
+		var cond string
+		cond, args = q.where("bucket_start")
+		rawCond, _ := q.where("ts_epoch")
+		args = append(args, q.loc().String())
+		tz := fmt.Sprintf("$%d", len(args))
+		sql = fmt.Sprintf(`
+SELECT c.bucket, c.cnt, COALESCE(u.uniq, 0), NULL::float8
+FROM (
+  SELECT %s AS bucket, SUM(count)::bigint AS cnt
+  FROM %s %s GROUP BY 1
+) c
+LEFT JOIN (
+  SELECT %s AS bucket, COUNT(DISTINCT user_id)::bigint AS uniq
+  FROM events %s GROUP BY 1
+) u USING (bucket)
+ORDER BY 1 ASC`, g.bucketExpr("bucket_start", tz), rg.countsTable(), cond, g.bucketExpr("ts_epoch", tz), rawCond)
+	} else {
+		var cond, value string
+		cond, args = q.where("ts_epoch")
+		value, args = q.aggregateSQL(args)
+		args = append(args, q.loc().String())
+		sql = fmt.Sprintf(`
 SELECT
-  EXTRACT(EPOCH FROM date_trunc('day', to_timestamp(ts_epoch)))::bigint AS bucket_start,
+  %s AS bucket_start,
   COUNT(*)::bigint AS cnt,
-  COUNT(DISTINCT user_id)::bigint AS uniq
+  COUNT(DISTINCT user_id)::bigint AS uniq,
+  %s AS value
 FROM events
 %s
 GROUP BY 1
-ORDER BY 1 ASC`, cond)
+ORDER BY 1 ASC`, g.bucketExpr("ts_epoch", fmt.Sprintf("$%d", len(args))), value, cond)
+	}
 
 	rows, err := db.Pool.Query(ctx, sql, args...)
 	if err != nil {
@@ -76,10 +329,16 @@ ORDER BY 1 ASC`, cond)
 	var out []MetricsBucket
 	for rows.Next() {
 		var b MetricsBucket
-		if err := rows.Scan(&b.BucketStart, &b.Count, &b.UniqueUsers); err != nil {
+		if err := rows.Scan(&b.BucketStart, &b.Count, &b.UniqueUsers, &b.Value); err != nil {
 			return nil, fmt.Errorf("scan bucket: %w", err)
 		}
 		out = append(out, b)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q.Fill {
+		out = fillBuckets(out, q.From, q.To, g, q.loc())
+	}
+	return out, nil
 }
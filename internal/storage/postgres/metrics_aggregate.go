@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AggFunc is a numeric aggregation over a metadata field.
+type AggFunc string
+
+const (
+	AggSum AggFunc = "sum"
+	AggAvg AggFunc = "avg"
+	AggMin AggFunc = "min"
+	AggMax AggFunc = "max"
+	AggP50 AggFunc = "p50"
+	AggP90 AggFunc = "p90"
+	AggP95 AggFunc = "p95"
+	AggP99 AggFunc = "p99"
+)
+
+var aggPercentiles = map[AggFunc]string{AggP50: "0.5", AggP90: "0.9", AggP95: "0.95", AggP99: "0.99"}
+
+// Aggregate computes Func over the numeric values at metadata Path, e.g.
+// "sum:metadata.amount". Events whose value is missing or not a JSON number
+// are left out (but still counted in Count and UniqueUsers).
+type Aggregate struct {
+	Func AggFunc
+	Path []string
+}
+
+// ParseAggregate parses "<func>:metadata.<path>".
+func ParseAggregate(s string) (Aggregate, error) {
+	fn, field, ok := strings.Cut(s, ":")
+	if !ok {
+		return Aggregate{}, fmt.Errorf("%q: want <func>:metadata.<path>, e.g. sum:metadata.amount", s)
+	}
+	a := Aggregate{Func: AggFunc(fn)}
+	switch a.Func {
+	case AggSum, AggAvg, AggMin, AggMax, AggP50, AggP90, AggP95, AggP99:
+	default:
+		return Aggregate{}, fmt.Errorf("unknown function %q (want sum, avg, min, max, p50, p90, p95 or p99)", fn)
+	}
+	path, ok := strings.CutPrefix(field, "metadata.")
+	if !ok || path == "" {
+		return Aggregate{}, fmt.Errorf("%q: field must be metadata.<path>", field)
+	}
+	a.Path = strings.Split(path, ".")
+	if len(a.Path) > MaxFilterDepth {
+		return Aggregate{}, fmt.Errorf("%q: metadata path deeper than %d", field, MaxFilterDepth)
+	}
+	for _, seg := range a.Path {
+		if !pathSegment.MatchString(seg) {
+			return Aggregate{}, fmt.Errorf("%q: invalid metadata path segment %q", field, seg)
+		}
+	}
+	return a, nil
+}
+
+func (a Aggregate) String() string {
+	return string(a.Func) + ":metadata." + strings.Join(a.Path, ".")
+}
+
+// numericAt is the number at the text[] parameter path in metadata, NULL for
+// any other JSON type.
+func numericAt(path string) string {
+	return fmt.Sprintf("CASE WHEN jsonb_typeof(metadata #> %s) = 'number' THEN (metadata #> %s)::numeric END", path, path)
+}
+
+// of applies a to the numeric column or expression v; NULL when no row has
+// a value.
+func (a Aggregate) of(v string) string {
+	if p, ok := aggPercentiles[a.Func]; ok {
+		return fmt.Sprintf("percentile_cont(%s) WITHIN GROUP (ORDER BY (%s)::float8)", p, v)
+	}
+	return fmt.Sprintf("%s(%s)::float8", strings.ToUpper(string(a.Func)), v)
+}
+
+// aggregateSQL is the select-list expression for q.Aggregate over events,
+// appending its parameter to args; a NULL constant without an aggregate.
+func (q MetricsQuery) aggregateSQL(args []any) (string, []any) {
+	if q.Aggregate == nil {
+		return "NULL::float8", args
+	}
+	args = append(args, q.Aggregate.Path)
+	return q.Aggregate.of(numericAt(fmt.Sprintf("$%d::text[]", len(args)))), args
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseAggregate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Aggregate
+	}{
+		{"sum:metadata.amount", Aggregate{Func: AggSum, Path: []string{"amount"}}},
+		{"avg:metadata.cart.total", Aggregate{Func: AggAvg, Path: []string{"cart", "total"}}},
+		{"min:metadata.a_b", Aggregate{Func: AggMin, Path: []string{"a_b"}}},
+		{"max:metadata.x-y", Aggregate{Func: AggMax, Path: []string{"x-y"}}},
+		{"p50:metadata.latency", Aggregate{Func: AggP50, Path: []string{"latency"}}},
+		{"p99:metadata.a.b.c.d.e.f.g.h", Aggregate{Func: AggP99, Path: strings.Split("a.b.c.d.e.f.g.h", ".")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseAggregate(tc.in)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %#v, want %#v", got, tc.want)
+			}
+			if got.String() != tc.in {
+				t.Fatalf("String() = %q", got.String())
+			}
+		})
+	}
+}
+
+func TestParseAggregateRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"sum",
+		"sum:",
+		"sum:amount",
+		"sum:metadata.",
+		"sum:metadata.a..b",
+		"sum:metadata.a b",
+		"sum:metadata.a.b.c.d.e.f.g.h.i",
+		"SUM:metadata.amount",
+		"count:metadata.amount",
+		"median:metadata.amount",
+		"p0:metadata.amount",
+		"p100:metadata.amount",
+		"p999:metadata.amount",
+		"p-1:metadata.amount",
+		"p50.5:metadata.amount",
+		"p:metadata.amount",
+	} {
+		t.Run(in, func(t *testing.T) {
+			if a, err := ParseAggregate(in); err == nil {
+				t.Fatalf("accepted as %#v", a)
+			}
+		})
+	}
+}
+
+func TestPercentileBounds(t *testing.T) {
+	for fn, p := range aggPercentiles {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v <= 0 || v >= 1 {
+			t.Fatalf("%s: fraction %q must be in (0, 1)", fn, p)
+		}
+		if want := "0." + strings.TrimPrefix(string(fn), "p"); strings.TrimRight(want, "0") != strings.TrimRight(p, "0") {
+			t.Fatalf("%s maps to %s", fn, p)
+		}
+		if got := (Aggregate{Func: fn}).of("v"); got != "percentile_cont("+p+") WITHIN GROUP (ORDER BY (v)::float8)" {
+			t.Fatalf("%s renders %q", fn, got)
+		}
+	}
+}
+
+func TestAggregateSQL(t *testing.T) {
+	q := MetricsQuery{}
+	if expr, args := q.aggregateSQL([]any{1}); expr != "NULL::float8" || len(args) != 1 {
+		t.Fatalf("no aggregate: %q %v", expr, args)
+	}
+	q.Aggregate = &Aggregate{Func: AggSum, Path: []string{"amount"}}
+	expr, args := q.aggregateSQL([]any{1, 2})
+	if !strings.HasPrefix(expr, "SUM(CASE WHEN jsonb_typeof(metadata #> $3::text[]) = 'number'") {
+		t.Fatalf("expr = %q", expr)
+	}
+	if !reflect.DeepEqual(args[2], []string{"amount"}) {
+		t.Fatalf("args = %#v", args)
+	}
+}
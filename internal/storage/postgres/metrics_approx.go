@@ -0,0 +1,195 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"example.com/goAssignment1/internal/hll"
+)
+
+// ErrSketchesMissing means part of the range is covered by rollup rows that
+// have no sketch yet (data from before sketches; see `rollups backfill`).
+var ErrSketchesMissing = errors.New("rollup sketches missing for part of the range")
+
+// ErrNoRollups means no rollup can answer any part of the query (filters, an
+// aggregate, a zone or a range the rollups do not fit), so there is nothing
+// to merge sketches from; callers should count exactly instead.
+var ErrNoRollups = errors.New("no rollups usable for the query")
+
+// UniqueUsersRelError is the relative standard error of approximate unique counts.
+var UniqueUsersRelError = hll.RelativeError(hll.Precision)
+
+// segment is a half-open [from, to) slice of a query range and the table it
+// is read from (grain nil: raw events).
+type segment struct {
+	grain    *rollupGrain
+	from, to int64
+}
+
+// splitRange covers [from, to) with daily rollups where whole days fit, hourly
+// rollups for the remaining whole hours, and raw events for the edges. Rollups
+// rejected by usable (e.g. wider than a bucket) are skipped.
+func splitRange(from, to int64, usable func(rollupGrain) bool) []segment {
+	var out []segment
+	var split func(from, to int64, level int)
+	split = func(from, to int64, level int) {
+		if from >= to {
+			return
+		}
+		if level < 0 {
+			out = append(out, segment{from: from, to: to})
+			return
+		}
+		g := &rollupGrains[level]
+		if !usable(*g) {
+			split(from, to, level-1)
+			return
+		}
+		lo := (from + g.span - 1) / g.span * g.span
+		hi := to / g.span * g.span
+		if lo >= hi {
+			split(from, to, level-1)
+			return
+		}
+		split(from, lo, level-1)
+		out = append(out, segment{grain: g, from: lo, to: hi})
+		split(hi, to, level-1)
+	}
+	split(from, to, len(rollupGrains)-1)
+	return out
+}
+
+type approxAgg struct {
+	count int64
+	users *hll.Sketch
+}
+
+// scanApprox aggregates q into buckets of granularity g (totals: "", a single
+// bucket): counts are exact, users are merged sketches. Raw edges of the range
+// are reduced to distinct (bucket, user) pairs in SQL. It returns ErrNoRollups
+// when the whole range would be read from raw events.
+func (db *DB) scanApprox(ctx context.Context, q MetricsQuery, g Granularity) (map[int64]*approxAgg, error) {
+	loc := q.loc()
+	usable := func(rg rollupGrain) bool { return rg.span <= g.Span() && q.rollupUsable(rg) }
+	segs := splitRange(q.From, q.To+1, usable)
+	if !slices.ContainsFunc(segs, func(s segment) bool { return s.grain != nil }) {
+		return nil, ErrNoRollups
+	}
+	aggs := map[int64]*approxAgg{}
+	agg := func(ts int64) *approxAgg {
+		b := g.BucketStart(ts, loc)
+		a, ok := aggs[b]
+		if !ok {
+			a = &approxAgg{users: hll.New(hll.Precision)}
+			aggs[b] = a
+		}
+		return a
+	}
+
+	for _, seg := range segs {
+		sq := q
+		sq.From, sq.To = seg.from, seg.to-1
+		if seg.grain == nil {
+			cond, args := sq.where("ts_epoch")
+			bucket := "0::bigint"
+			if g != "" {
+				args = append(args, loc.String())
+				bucket = g.bucketExpr("ts_epoch", fmt.Sprintf("$%d", len(args)))
+			}
+			rows, err := db.Pool.Query(ctx, "SELECT "+bucket+", user_id, COUNT(*) FROM events "+cond+" GROUP BY 1, 2", args...)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var (
+					bucket, count int64
+					user          string
+				)
+				if err := rows.Scan(&bucket, &user, &count); err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("scan event: %w", err)
+				}
+				a := agg(bucket)
+				a.count += count
+				a.users.AddString(user)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		cond, args := sq.where("bucket_start")
+		rows, err := db.Pool.Query(ctx, "SELECT bucket_start, count, users_hll FROM "+seg.grain.countsTable()+" "+cond, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				bucket, count int64
+				blob          []byte
+				sk            hll.Sketch
+			)
+			if err := rows.Scan(&bucket, &count, &blob); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan rollup: %w", err)
+			}
+			if blob == nil {
+				rows.Close()
+				return nil, ErrSketchesMissing
+			}
+			if err := sk.UnmarshalBinary(blob); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("decode users_hll: %w", err)
+			}
+			a := agg(bucket)
+			a.count += count
+			if err := a.users.Merge(&sk); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return aggs, nil
+}
+
+// QueryApprox is QueryTotals and, unless g is empty, QueryBuckets with unique
+// users estimated from merged sketches, for any range. Both come from one scan
+// so that they are approximate together or not at all: the totals merge the
+// bucket sketches. It returns ErrSketchesMissing when the range touches
+// unsketched rollup rows and ErrNoRollups when no rollup can serve it.
+func (db *DB) QueryApprox(ctx context.Context, q MetricsQuery, g Granularity) (MetricsTotals, []MetricsBucket, error) {
+	defer observeQuery("approx_"+string(g), time.Now())
+	aggs, err := db.scanApprox(ctx, q, g)
+	if err != nil {
+		return MetricsTotals{}, nil, err
+	}
+	all := hll.New(hll.Precision)
+	var tot MetricsTotals
+	out := make([]MetricsBucket, 0, len(aggs))
+	for b, a := range aggs {
+		tot.Count += a.count
+		if err := all.Merge(a.users); err != nil {
+			return MetricsTotals{}, nil, err
+		}
+		out = append(out, MetricsBucket{BucketStart: b, Count: a.count, UniqueUsers: int64(a.users.Estimate())})
+	}
+	tot.UniqueUsers = int64(all.Estimate())
+	if g == "" {
+		return tot, nil, nil
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].BucketStart < out[j].BucketStart })
+	if q.Fill {
+		out = fillBuckets(out, q.From, q.To, g, q.loc())
+	}
+	return tot, out, nil
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FilterOp compares a metadata value.
+type FilterOp string
+
+const (
+	FilterEq  FilterOp = "=="
+	FilterNe  FilterOp = "!="
+	FilterGt  FilterOp = ">"
+	FilterGte FilterOp = ">="
+	FilterLt  FilterOp = "<"
+	FilterLte FilterOp = "<="
+)
+
+// two-character operators first so ">=" is not read as ">"
+var filterOps = []FilterOp{FilterEq, FilterNe, FilterGte, FilterLte, FilterGt, FilterLt}
+
+// MaxFilterDepth bounds the nesting of a metadata path.
+const MaxFilterDepth = 8
+
+var (
+	pathSegment = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	jsonNumber  = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// Filter is one condition on an event's tags or metadata:
+//
+//	tag:promo                  tags contain "promo"
+//	metadata.currency==USD     metadata.currency equals "USD"
+//	metadata.amount>=100       metadata.amount is a number >= 100
+//
+// Values are JSON scalars: numbers, true, false, null, "quoted strings";
+// anything else is a string. Metadata without the path matches only !=.
+type Filter struct {
+	Tag   string   // tag filter; empty for metadata filters
+	Path  []string // metadata path
+	Op    FilterOp
+	Value any // string, json.Number, bool or nil
+}
+
+// ParseFilter parses one filter expression.
+func ParseFilter(s string) (Filter, error) {
+	if tag, ok := strings.CutPrefix(s, "tag:"); ok {
+		if tag == "" {
+			return Filter{}, errors.New("tag filter needs a tag, e.g. tag:promo")
+		}
+		return Filter{Tag: tag}, nil
+	}
+	rest, ok := strings.CutPrefix(s, "metadata.")
+	if !ok {
+		return Filter{}, fmt.Errorf("%q: want tag:<tag> or metadata.<path><op><value>", s)
+	}
+
+	at, op := -1, FilterOp("")
+	for _, o := range filterOps {
+		if i := strings.Index(rest, string(o)); i >= 0 && (at < 0 || i < at) {
+			at, op = i, o
+		}
+	}
+	if at < 0 {
+		return Filter{}, fmt.Errorf("%q: missing operator (==, !=, >, >=, <, <=)", s)
+	}
+	f := Filter{Path: strings.Split(rest[:at], "."), Op: op}
+	if len(f.Path) > MaxFilterDepth {
+		return Filter{}, fmt.Errorf("%q: metadata path deeper than %d", s, MaxFilterDepth)
+	}
+	for _, seg := range f.Path {
+		if !pathSegment.MatchString(seg) {
+			return Filter{}, fmt.Errorf("%q: invalid metadata path segment %q", s, seg)
+		}
+	}
+
+	raw := rest[at+len(op):]
+	switch {
+	case raw == "true" || raw == "false":
+		f.Value = raw == "true"
+	case raw == "null":
+		f.Value = nil
+	case len(raw) >= 2 && raw[0] == '"':
+		var str string
+		if err := json.Unmarshal([]byte(raw), &str); err != nil {
+			return Filter{}, fmt.Errorf("%q: bad quoted string", s)
+		}
+		f.Value = str
+	case jsonNumber.MatchString(raw):
+		f.Value = json.Number(raw)
+	default:
+		f.Value = raw
+	}
+	if op != FilterEq && op != FilterNe {
+		switch f.Value.(type) {
+		case json.Number, string:
+		default:
+			return Filter{}, fmt.Errorf("%q: %s needs a number or string", s, op)
+		}
+	}
+	return f, nil
+}
+
+func (f Filter) String() string {
+	if f.Tag != "" {
+		return "tag:" + f.Tag
+	}
+	v, _ := json.Marshal(f.Value)
+	return "metadata." + strings.Join(f.Path, ".") + string(f.Op) + string(v)
+}
+
+// sql renders f as a condition on the events columns, appending its
+// parameters to args. Equality uses containment so the GIN indexes apply.
+func (f Filter) sql(args []any) (string, []any) {
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Tag != "" {
+		b, _ := json.Marshal([]string{f.Tag})
+		return "tags @> " + arg(string(b)) + "::jsonb", args
+	}
+
+	switch f.Op {
+	case FilterEq, FilterNe:
+		var doc any = f.Value
+		for i := len(f.Path) - 1; i >= 0; i-- {
+			doc = map[string]any{f.Path[i]: doc}
+		}
+		b, _ := json.Marshal(doc)
+		cond := "metadata @> " + arg(string(b)) + "::jsonb"
+		if f.Op == FilterNe {
+			cond = "NOT COALESCE(" + cond + ", false)"
+		}
+		return cond, args
+	}
+
+	// ordering: numbers compare with numbers, strings with strings; values
+	// of another JSON type never match
+	path := arg(f.Path) + "::text[]"
+	if n, ok := f.Value.(json.Number); ok {
+		return fmt.Sprintf("%s %s %s::text::numeric", numericAt(path), f.Op, arg(n.String())), args
+	}
+	return fmt.Sprintf("CASE WHEN jsonb_typeof(metadata #> %s) = 'string' THEN metadata #>> %s END %s %s::text",
+		path, path, f.Op, arg(f.Value)), args
+}
@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Filter
+	}{
+		{"tag:promo", Filter{Tag: "promo"}},
+		{"tag:a:b", Filter{Tag: "a:b"}},
+		{"metadata.currency==USD", Filter{Path: []string{"currency"}, Op: FilterEq, Value: "USD"}},
+		{"metadata.a.b-c.d_e!=x", Filter{Path: []string{"a", "b-c", "d_e"}, Op: FilterNe, Value: "x"}},
+		{"metadata.amount>=100", Filter{Path: []string{"amount"}, Op: FilterGte, Value: json.Number("100")}},
+		{"metadata.amount<=-0.5", Filter{Path: []string{"amount"}, Op: FilterLte, Value: json.Number("-0.5")}},
+		{"metadata.amount>1e3", Filter{Path: []string{"amount"}, Op: FilterGt, Value: json.Number("1e3")}},
+		{"metadata.amount<2.5E-2", Filter{Path: []string{"amount"}, Op: FilterLt, Value: json.Number("2.5E-2")}},
+		// not JSON numbers: compared as strings
+		{"metadata.zip==01234", Filter{Path: []string{"zip"}, Op: FilterEq, Value: "01234"}},
+		{"metadata.v==1.", Filter{Path: []string{"v"}, Op: FilterEq, Value: "1."}},
+		{"metadata.v==+1", Filter{Path: []string{"v"}, Op: FilterEq, Value: "+1"}},
+		{`metadata.amount=="100"`, Filter{Path: []string{"amount"}, Op: FilterEq, Value: "100"}},
+		{`metadata.name>"b\"c"`, Filter{Path: []string{"name"}, Op: FilterGt, Value: `b"c`}},
+		{"metadata.paid==true", Filter{Path: []string{"paid"}, Op: FilterEq, Value: true}},
+		{"metadata.paid!=false", Filter{Path: []string{"paid"}, Op: FilterNe, Value: false}},
+		{"metadata.coupon==null", Filter{Path: []string{"coupon"}, Op: FilterEq, Value: nil}},
+		{"metadata.note==", Filter{Path: []string{"note"}, Op: FilterEq, Value: ""}},
+		// the first operator splits; the rest belongs to the value
+		{"metadata.expr==a>=b", Filter{Path: []string{"expr"}, Op: FilterEq, Value: "a>=b"}},
+		{"metadata.n>=5", Filter{Path: []string{"n"}, Op: FilterGte, Value: json.Number("5")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseFilter(tc.in)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"tag:",
+		"promo",
+		"tags:promo",
+		"meta.amount>1",
+		"metadata.amount",
+		"metadata.amount=1",
+		"metadata.==1",
+		"metadata.a..b==1",
+		"metadata.a b==1",
+		"metadata.a'b==1",
+		"metadata.a;DROP==1",
+		"metadata.a.b.c.d.e.f.g.h.i==1",
+		`metadata.name=="unterminated`,
+		"metadata.paid>true",
+		"metadata.coupon<=null",
+	} {
+		t.Run(in, func(t *testing.T) {
+			if f, err := ParseFilter(in); err == nil {
+				t.Fatalf("accepted as %#v", f)
+			}
+		})
+	}
+}
+
+func TestFilterStringRoundTrip(t *testing.T) {
+	for _, in := range []string{"tag:promo", "metadata.a.b==x", "metadata.n>=1.5", "metadata.ok!=true", "metadata.c==null"} {
+		f, err := ParseFilter(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := ParseFilter(f.String())
+		if err != nil || !reflect.DeepEqual(back, f) {
+			t.Fatalf("%s: String()=%s parses to %#v (%v)", in, f.String(), back, err)
+		}
+	}
+}
+
+func TestFilterSQL(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantCond string
+		wantArgs []any
+	}{
+		{"tag:promo", "tags @> $2::jsonb", []any{`["promo"]`}},
+		{"metadata.a.b==1", "metadata @> $2::jsonb", []any{`{"a":{"b":1}}`}},
+		{"metadata.cur!=USD", `NOT COALESCE(metadata @> $2::jsonb, false)`, []any{`{"cur":"USD"}`}},
+		{"metadata.amount>=100", "metadata #> $2::text[]", []any{[]string{"amount"}, "100"}},
+		{"metadata.name<m", "jsonb_typeof(metadata #> $2::text[]) = 'string'", []any{[]string{"name"}, "m"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			f, err := ParseFilter(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cond, args := f.sql([]any{"existing"})
+			if !strings.Contains(cond, tc.wantCond) {
+				t.Fatalf("cond %q does not contain %q", cond, tc.wantCond)
+			}
+			if !reflect.DeepEqual(args[1:], tc.wantArgs) || args[0] != "existing" {
+				t.Fatalf("args = %#v, want existing + %#v", args, tc.wantArgs)
+			}
+			// user input only ever travels as a parameter
+			if strings.Contains(cond, "promo") || strings.Contains(cond, "USD") || strings.Contains(cond, "amount") {
+				t.Fatalf("value inlined into SQL: %q", cond)
+			}
+		})
+	}
+}
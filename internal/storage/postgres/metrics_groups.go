@@ -0,0 +1,252 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dimension is an attribute /metrics can break results down by.
+type Dimension string
+
+const (
+	DimensionChannel    Dimension = "channel"
+	DimensionCampaignID Dimension = "campaign_id"
+	DimensionEventName  Dimension = "event_name"
+	DimensionTag        Dimension = "tag" // one group per individual tag
+)
+
+// MaxDimensions is how many dimensions one query may group by.
+const MaxDimensions = 2
+
+// ParseDimensions parses a comma-separated list of up to MaxDimensions
+// distinct dimensions.
+func ParseDimensions(s string) ([]Dimension, error) {
+	var out []Dimension
+	for _, part := range strings.Split(s, ",") {
+		d := Dimension(strings.TrimSpace(part))
+		switch d {
+		case DimensionChannel, DimensionCampaignID, DimensionEventName, DimensionTag:
+		default:
+			return nil, fmt.Errorf("unknown dimension %q (want channel, campaign_id, event_name or tag)", d)
+		}
+		for _, prev := range out {
+			if prev == d {
+				return nil, fmt.Errorf("dimension %q given twice", d)
+			}
+		}
+		out = append(out, d)
+	}
+	if len(out) > MaxDimensions {
+		return nil, fmt.Errorf("at most %d dimensions", MaxDimensions)
+	}
+	return out, nil
+}
+
+// MetricsGroup is one dimension combination, or the "other" group merging
+// everything outside the top N.
+type MetricsGroup struct {
+	Values  []*string // per requested dimension; nil = events without a value
+	Other   bool
+	Merged  int // other: number of groups merged into it
+	Totals  MetricsTotals
+	Buckets []MetricsBucket
+}
+
+// tagsJoin expands the tags of events e into rows t(tag); untagged events
+// keep one row with a NULL tag.
+const tagsJoin = " LEFT JOIN LATERAL jsonb_array_elements_text(CASE WHEN jsonb_typeof(e.tags) = 'array' THEN e.tags ELSE '[]' END) AS t(tag) ON TRUE"
+
+// rawDimExpr is the SQL for d over events e; tags come from the lateral
+// join t. Missing values become the empty string, as in the rollup tables.
+func rawDimExpr(d Dimension) string {
+	switch d {
+	case DimensionTag:
+		return "COALESCE(t.tag, '')"
+	case DimensionEventName:
+		return "e.event_name"
+	}
+	return "COALESCE(e." + string(d) + ", '')"
+}
+
+// QueryGroups breaks q down by dims: the limit largest groups (by event
+// count) and, if there are more, one "other" group holding the rest. Unique
+// users are exact per group, including "other". With g set each group also
+// carries buckets (all of them with q.Fill). An event with several tags
+// counts once per tag.
+func (db *DB) QueryGroups(ctx context.Context, q MetricsQuery, dims []Dimension, limit int, g Granularity) ([]MetricsGroup, error) {
+	defer observeQuery("groups", time.Now())
+
+	hasTag := false
+	for _, d := range dims {
+		hasTag = hasTag || d == DimensionTag
+	}
+	maxSpan := int64(math.MaxInt64)
+	if g != "" {
+		maxSpan = g.Span()
+	}
+
+	d1, d2 := "''", "''"
+	var cntSrc, usrSrc string
+	var args []any
+	if rg, ok := q.rollupFor(maxSpan); ok && !hasTag {
+		// rollup columns already store missing values as empty strings
+		dim := func(d Dimension) string { return string(d) }
+		d1 = dim(dims[0])
+		if len(dims) > 1 {
+			d2 = dim(dims[1])
+		}
+		var cond string
+		cond, args = q.where("bucket_start")
+		cntSrc = fmt.Sprintf("SELECT %s AS d1, %s AS d2, bucket_start AS ts, count AS n, NULL::numeric AS v FROM %s %s", d1, d2, rg.countsTable(), cond)
+		// exact unique users need the raw rows (same filter, same args)
+		r1, r2 := rawDimExpr(dims[0]), "''"
+		if len(dims) > 1 {
+			r2 = rawDimExpr(dims[1])
+		}
+		rawCond, _ := q.where("e.ts_epoch")
+		usrSrc = fmt.Sprintf("SELECT %s AS d1, %s AS d2, e.ts_epoch AS ts, e.user_id FROM events e %s", r1, r2, rawCond)
+	} else {
+		d1 = rawDimExpr(dims[0])
+		if len(dims) > 1 {
+			d2 = rawDimExpr(dims[1])
+		}
+		var cond string
+		cond, args = q.where("e.ts_epoch")
+		from := "events e"
+		if hasTag {
+			from += tagsJoin
+		}
+		v := "NULL::numeric"
+		if q.Aggregate != nil {
+			args = append(args, q.Aggregate.Path)
+			v = numericAt(fmt.Sprintf("$%d::text[]", len(args)))
+		}
+		cntSrc = fmt.Sprintf("SELECT %s AS d1, %s AS d2, e.ts_epoch AS ts, 1 AS n, %s AS v, e.user_id FROM %s %s", d1, d2, v, from, cond)
+		usrSrc = "SELECT d1, d2, ts, user_id FROM cnt"
+	}
+
+	value := "NULL::float8"
+	if q.Aggregate != nil {
+		value = q.Aggregate.of("x.v")
+	}
+	args = append(args, limit)
+	limitArg := fmt.Sprintf("$%d", len(args))
+	bucket, groupBy := "NULL::bigint", "GROUP BY 1, 2, 3"
+	if g != "" {
+		args = append(args, q.loc().String())
+		bucket = g.bucketExpr("x.ts", fmt.Sprintf("$%d", len(args)))
+		// per-bucket rows plus per-group totals (bucket NULL) in one pass
+		groupBy = "GROUP BY GROUPING SETS ((1, 2, 3, 4), (1, 2, 3))"
+	}
+
+	sql := fmt.Sprintf(`
+WITH cnt AS (%s
+), usr AS (%s
+), ranked AS (
+  SELECT d1, d2, ROW_NUMBER() OVER (ORDER BY SUM(n) DESC, d1, d2) AS rnk
+  FROM cnt GROUP BY d1, d2
+), c AS (
+  SELECT r.rnk <= %[3]s AS top,
+         CASE WHEN r.rnk <= %[3]s THEN x.d1 END AS d1,
+         CASE WHEN r.rnk <= %[3]s THEN x.d2 END AS d2,
+         %[4]s AS bucket, SUM(x.n)::bigint AS cnt, %[6]s AS value
+  FROM cnt x JOIN ranked r ON r.d1 = x.d1 AND r.d2 = x.d2
+  %[5]s
+), u AS (
+  SELECT r.rnk <= %[3]s AS top,
+         CASE WHEN r.rnk <= %[3]s THEN x.d1 END AS d1,
+         CASE WHEN r.rnk <= %[3]s THEN x.d2 END AS d2,
+         %[4]s AS bucket, COUNT(DISTINCT x.user_id)::bigint AS uniq
+  FROM usr x JOIN ranked r ON r.d1 = x.d1 AND r.d2 = x.d2
+  %[5]s
+)
+SELECT c.top, c.d1, c.d2, c.bucket, c.cnt, COALESCE(u.uniq, 0), c.value,
+       (SELECT COUNT(*) FROM ranked WHERE rnk > %[3]s)
+FROM c
+LEFT JOIN u ON u.top = c.top AND u.d1 IS NOT DISTINCT FROM c.d1
+  AND u.d2 IS NOT DISTINCT FROM c.d2 AND u.bucket IS NOT DISTINCT FROM c.bucket`, cntSrc, usrSrc, limitArg, bucket, groupBy, value)
+
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type gkey struct {
+		top    bool
+		d1, d2 string
+	}
+	groups := map[gkey]*MetricsGroup{}
+	var order []gkey
+	for rows.Next() {
+		var (
+			top         bool
+			v1, v2      *string
+			bucket      *int64
+			cnt, uniq   int64
+			value       *float64
+			otherGroups int
+		)
+		if err := rows.Scan(&top, &v1, &v2, &bucket, &cnt, &uniq, &value, &otherGroups); err != nil {
+			return nil, fmt.Errorf("scan group: %w", err)
+		}
+		k := gkey{top: top}
+		if v1 != nil {
+			k.d1 = *v1
+		}
+		if v2 != nil {
+			k.d2 = *v2
+		}
+		grp, ok := groups[k]
+		if !ok {
+			grp = &MetricsGroup{Other: !top}
+			if top {
+				grp.Values = []*string{dimValue(k.d1)}
+				if len(dims) > 1 {
+					grp.Values = append(grp.Values, dimValue(k.d2))
+				}
+			} else {
+				grp.Merged = otherGroups
+			}
+			groups[k] = grp
+			order = append(order, k)
+		}
+		if bucket == nil {
+			grp.Totals = MetricsTotals{Count: cnt, UniqueUsers: uniq, Value: value}
+		} else {
+			grp.Buckets = append(grp.Buckets, MetricsBucket{BucketStart: *bucket, Count: cnt, UniqueUsers: uniq, Value: value})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]MetricsGroup, 0, len(order))
+	for _, k := range order {
+		grp := groups[k]
+		sort.Slice(grp.Buckets, func(i, j int) bool { return grp.Buckets[i].BucketStart < grp.Buckets[j].BucketStart })
+		if g != "" && q.Fill {
+			grp.Buckets = fillBuckets(grp.Buckets, q.From, q.To, g, q.loc())
+		}
+		out = append(out, *grp)
+	}
+	// largest first, "other" last
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Other != out[j].Other {
+			return !out[i].Other
+		}
+		return out[i].Totals.Count > out[j].Totals.Count
+	})
+	return out, nil
+}
+
+func dimValue(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+// sqlSubDayBucket evaluates the minute/hour bucketExpr the way Postgres does:
+// the offset is the zone's wall clock read as UTC minus the instant.
+func sqlSubDayBucket(ts int64, g Granularity, loc *time.Location) int64 {
+	t := time.Unix(ts, 0).In(loc)
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Unix()
+	off, span := wall-ts, g.Span()
+	return ts - ((ts+off)%span+span)%span
+}
+
+func TestSubDayBucketsMatchSQL(t *testing.T) {
+	nights := []struct {
+		zone string
+		utc  string // a few hours before the transition
+	}{
+		{"Europe/Berlin", "2024-10-26T22:00:00Z"},       // fall back 03:00 -> 02:00
+		{"Europe/Berlin", "2024-03-30T22:00:00Z"},       // spring forward 02:00 -> 03:00
+		{"America/New_York", "2024-11-03T03:00:00Z"},    // fall back 02:00 -> 01:00
+		{"Australia/Lord_Howe", "2024-04-06T12:00:00Z"}, // half-hour fall back
+		{"Asia/Kolkata", "2024-06-01T00:00:00Z"},        // +05:30, no DST
+	}
+	for _, n := range nights {
+		loc := mustLoad(t, n.zone)
+		start, _ := time.Parse(time.RFC3339, n.utc)
+		for _, g := range []Granularity{GranularityMinute, GranularityHour} {
+			for ts := start.Unix(); ts < start.Unix()+8*3600; ts += 60 {
+				if got, want := g.BucketStart(ts, loc), sqlSubDayBucket(ts, g, loc); got != want {
+					t.Fatalf("%s %s ts=%d: BucketStart=%d, SQL=%d", n.zone, g, ts, got, want)
+				}
+			}
+		}
+	}
+	if strings.Contains(GranularityHour.bucketExpr("ts_epoch", "$3"), "date_trunc") {
+		t.Fatal("hour buckets must not use wall-clock truncation")
+	}
+}
+
+func TestHourBucketsAcrossDST(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	tests := []struct {
+		name     string
+		from, to string // local midnight to 06:00 of the transition night
+		want     int
+	}{
+		{"fall back", "2024-10-26T22:00:00Z", "2024-10-27T04:59:59Z", 7},
+		{"spring forward", "2024-03-30T23:00:00Z", "2024-03-31T03:59:59Z", 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			from, _ := time.Parse(time.RFC3339, tc.from)
+			to, _ := time.Parse(time.RFC3339, tc.to)
+
+			// one event in every real hour of the night
+			var bs []MetricsBucket
+			for ts := from.Unix() + 1800; ts <= to.Unix(); ts += 3600 {
+				b := GranularityHour.BucketStart(ts, berlin)
+				if len(bs) > 0 && bs[len(bs)-1].BucketStart == b {
+					t.Fatalf("two real hours share bucket %d", b)
+				}
+				bs = append(bs, MetricsBucket{BucketStart: b, Count: 1})
+			}
+			filled := fillBuckets(bs, from.Unix(), to.Unix(), GranularityHour, berlin)
+			if len(filled) != tc.want {
+				t.Fatalf("buckets = %d, want %d", len(filled), tc.want)
+			}
+			for i, b := range filled {
+				if b.Count != 1 {
+					t.Fatalf("bucket %d (%s) has count %d: phantom or merged bucket", i, time.Unix(b.BucketStart, 0).In(berlin), b.Count)
+				}
+				if i > 0 && b.BucketStart-filled[i-1].BucketStart != 3600 {
+					t.Fatalf("bucket %d starts %ds after the previous one", i, b.BucketStart-filled[i-1].BucketStart)
+				}
+			}
+		})
+	}
+}
+
+func TestDayBucketsAcrossDST(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	from := time.Date(2024, 10, 26, 0, 0, 0, 0, berlin).Unix()
+	to := time.Date(2024, 10, 28, 23, 59, 59, 0, berlin).Unix()
+	filled := fillBuckets(nil, from, to, GranularityDay, berlin)
+	var lengths []int64
+	for i := 1; i < len(filled); i++ {
+		lengths = append(lengths, filled[i].BucketStart-filled[i-1].BucketStart)
+	}
+	if len(filled) != 3 || lengths[0] != 24*3600 || lengths[1] != 25*3600 {
+		t.Fatalf("day buckets = %d with lengths %v, want 3 with 24h then 25h", len(filled), lengths)
+	}
+}
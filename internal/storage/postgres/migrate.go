@@ -0,0 +1,305 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string // empty if the migration cannot be reverted
+	Checksum string // sha256 of Up
+}
+
+// MigrationState is the applied state of a known migration.
+type MigrationState struct {
+	Migration
+	Applied          bool
+	AppliedAt        time.Time
+	ChecksumMismatch bool // applied from a different Up script
+}
+
+// migrationLockKey is the pg_advisory_lock key serializing migrators
+// (several replicas starting at once).
+const migrationLockKey = int64(0x6576656e74735f6d) // "events_m"
+
+// noTxMarker, as the first line of a script, runs it outside a transaction
+// (needed for CREATE INDEX CONCURRENTLY and the like). Such a script runs one
+// statement at a time and its schema_migrations row is written after it, so a
+// crash in between runs it again: every statement must be re-runnable, i.e.
+// use IF [NOT] EXISTS. A failed CREATE INDEX CONCURRENTLY leaves an invalid
+// index behind that IF NOT EXISTS would keep, so drop it first:
+//
+//	DROP INDEX CONCURRENTLY IF EXISTS idx;
+//	CREATE INDEX CONCURRENTLY IF NOT EXISTS idx ON ...;
+const noTxMarker = "-- migrate:no-transaction"
+
+var (
+	migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	idempotentSQL = regexp.MustCompile(`(?i)\bIF\s+(NOT\s+)?EXISTS\b`)
+)
+
+// LoadMigrations reads NNNN_name.up.sql / NNNN_name.down.sql files from fsys.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		v, _ := strconv.ParseInt(m[1], 10, 64)
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", e.Name(), err)
+		}
+		mig, ok := byVersion[v]
+		if !ok {
+			mig = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", v, mig.Name, m[2])
+		}
+		if err := checkNoTxScript(string(b)); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+			sum := sha256.Sum256(b)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no .up.sql", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+const schemaMigrationsDDL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version     BIGINT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    checksum    TEXT NOT NULL,
+    applied_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction, under an advisory lock. It refuses to run if an applied
+// migration's checksum no longer matches its embedded script.
+func (db *DB) MigrateUp(ctx context.Context, ms []Migration) (applied []Migration, err error) {
+	err = db.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		states, err := migrationStates(ctx, conn, ms)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			if st.ChecksumMismatch {
+				return fmt.Errorf("migration %d_%s was modified after being applied (checksum mismatch)", st.Version, st.Name)
+			}
+		}
+		for _, st := range states {
+			if st.Applied {
+				continue
+			}
+			err := runMigration(ctx, conn, st.Up,
+				"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+				st.Version, st.Name, st.Checksum)
+			if err != nil {
+				return fmt.Errorf("apply %d_%s: %w", st.Version, st.Name, err)
+			}
+			applied = append(applied, st.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first.
+func (db *DB) MigrateDown(ctx context.Context, ms []Migration, steps int) (reverted []Migration, err error) {
+	err = db.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		states, err := migrationStates(ctx, conn, ms)
+		if err != nil {
+			return err
+		}
+		for i := len(states) - 1; i >= 0 && len(reverted) < steps; i-- {
+			st := states[i]
+			if !st.Applied {
+				continue
+			}
+			if st.Down == "" {
+				return fmt.Errorf("migration %d_%s has no .down.sql", st.Version, st.Name)
+			}
+			err := runMigration(ctx, conn, st.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", st.Version)
+			if err != nil {
+				return fmt.Errorf("revert %d_%s: %w", st.Version, st.Name, err)
+			}
+			reverted = append(reverted, st.Migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus reports which of ms are applied.
+func (db *DB) MigrationStatus(ctx context.Context, ms []Migration) ([]MigrationState, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, schemaMigrationsDDL); err != nil {
+		return nil, fmt.Errorf("schema_migrations: %w", err)
+	}
+	return migrationStates(ctx, conn, ms)
+}
+
+func (db *DB) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}()
+
+	if _, err := conn.Exec(ctx, schemaMigrationsDDL); err != nil {
+		return fmt.Errorf("schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func migrationStates(ctx context.Context, conn *pgxpool.Conn, ms []Migration) ([]MigrationState, error) {
+	rows, err := conn.Query(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type row struct {
+		checksum  string
+		appliedAt time.Time
+	}
+	applied := map[int64]row{}
+	for rows.Next() {
+		var (
+			v int64
+			r row
+		)
+		if err := rows.Scan(&v, &r.checksum, &r.appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		applied[v] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationState, len(ms))
+	for i, m := range ms {
+		out[i] = MigrationState{Migration: m}
+		if r, ok := applied[m.Version]; ok {
+			out[i].Applied = true
+			out[i].AppliedAt = r.appliedAt
+			out[i].ChecksumMismatch = r.checksum != m.Checksum
+		}
+	}
+	return out, nil
+}
+
+func isNoTx(script string) bool {
+	return strings.HasPrefix(strings.TrimSpace(script), noTxMarker)
+}
+
+// checkNoTxScript rejects a no-transaction script with a statement that
+// cannot safely run twice (see noTxMarker).
+func checkNoTxScript(script string) error {
+	if !isNoTx(script) {
+		return nil
+	}
+	for _, stmt := range splitStatements(script) {
+		if !idempotentSQL.MatchString(stmt) {
+			return fmt.Errorf("no-transaction statement must be re-runnable (IF [NOT] EXISTS): %s", stmt)
+		}
+	}
+	return nil
+}
+
+// splitStatements splits a no-transaction script on semicolons after
+// dropping "--" comments. It does not understand quoting, so such scripts
+// must not contain semicolons in literals or function bodies.
+func splitStatements(script string) []string {
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		if i := strings.Index(line, "--"); i >= 0 {
+			line = line[:i]
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	var out []string
+	for _, stmt := range strings.Split(b.String(), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// runMigration executes a script plus its bookkeeping statement, inside one
+// transaction unless the script opts out with the no-transaction marker. A
+// no-transaction script runs statement by statement (several statements in
+// one Exec would share an implicit transaction) and is recorded only once all
+// of them succeeded; LoadMigrations has checked that it can run again.
+func runMigration(ctx context.Context, conn *pgxpool.Conn, script, bookkeeping string, args ...any) error {
+	if isNoTx(script) {
+		for _, stmt := range splitStatements(script) {
+			if _, err := conn.Exec(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		_, err := conn.Exec(ctx, bookkeeping, args...)
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
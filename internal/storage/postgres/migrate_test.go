@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- migrate:no-transaction
+-- drop a leftover from a failed run; then build
+DROP INDEX CONCURRENTLY IF EXISTS idx_a;
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_a
+    ON events (user_id); -- trailing comment
+
+`
+	want := []string{
+		"DROP INDEX CONCURRENTLY IF EXISTS idx_a",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_a\n    ON events (user_id)",
+	}
+	if got := splitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitStatements = %q, want %q", got, want)
+	}
+}
+
+func TestLoadMigrationsChecksNoTxScripts(t *testing.T) {
+	tests := []struct {
+		name    string
+		up      string
+		wantErr string
+	}{
+		{"transactional script is not checked", "CREATE INDEX idx_a ON events (user_id);", ""},
+		{"re-runnable", "-- migrate:no-transaction\nDROP INDEX CONCURRENTLY IF EXISTS idx_a;\nCREATE INDEX CONCURRENTLY IF NOT EXISTS idx_a ON events (user_id);", ""},
+		{"not re-runnable", "-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY idx_a ON events (user_id);", "must be re-runnable"},
+		{"one statement of two", "-- migrate:no-transaction\nDROP INDEX CONCURRENTLY IF EXISTS idx_a;\nCREATE INDEX CONCURRENTLY idx_a ON events (user_id);", "CREATE INDEX CONCURRENTLY idx_a"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"0001_idx.up.sql": {Data: []byte(tc.up)}}
+			_, err := LoadMigrations(fsys)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadMigrations: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("LoadMigrations err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
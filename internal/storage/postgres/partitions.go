@@ -0,0 +1,265 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PartitionInterval is the span of one events partition.
+type PartitionInterval string
+
+const (
+	PartitionDaily   PartitionInterval = "daily"
+	PartitionMonthly PartitionInterval = "monthly"
+)
+
+// partitionLockKey keeps replicas from carving the same partition at once.
+const partitionLockKey = int64(0x6576656e74735f70) // "events_p"
+
+// Partition is one attached range of the events table, [From, To) in epoch seconds.
+type Partition struct {
+	Name string
+	From int64
+	To   int64
+}
+
+// PartitionManager keeps events partitioned by ts_epoch: it pre-creates
+// partitions for upcoming periods and moves rows that landed in events_default
+// into partitions of their own.
+type PartitionManager struct {
+	db       *DB
+	interval PartitionInterval
+	premake  int
+	now      func() time.Time
+}
+
+// NewPartitionManager manages interval-sized partitions, keeping premake
+// future periods created ahead of time.
+func NewPartitionManager(db *DB, interval PartitionInterval, premake int) *PartitionManager {
+	if interval != PartitionDaily {
+		interval = PartitionMonthly
+	}
+	if premake < 1 {
+		premake = 1
+	}
+	return &PartitionManager{db: db, interval: interval, premake: premake, now: time.Now}
+}
+
+func (m *PartitionManager) Interval() PartitionInterval { return m.interval }
+
+// Run calls Ensure immediately and then every `every` until ctx is done.
+func (m *PartitionManager) Run(ctx context.Context, every time.Duration) {
+	if every <= 0 {
+		every = time.Hour
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		created, err := m.Ensure(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[partitions] ensure failed: %v", err)
+		}
+		for _, name := range created {
+			log.Printf("[partitions] created %s", name)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Ensure creates missing partitions for the current and the next premake
+// periods, plus any period that currently has rows in events_default.
+// It is a no-op when another instance holds the partition lock.
+func (m *PartitionManager) Ensure(ctx context.Context) (created []string, err error) {
+	conn, err := m.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", partitionLockKey).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("advisory lock: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", partitionLockKey)
+	}()
+
+	existing, err := m.db.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	want := map[int64]bool{}
+	start := m.periodStart(m.now().UTC())
+	for i := 0; i <= m.premake; i++ {
+		want[start.Unix()] = true
+		start = m.next(start)
+	}
+	stray, err := m.defaultPeriods(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stray {
+		want[p] = true
+	}
+
+	for from := range want {
+		lo := time.Unix(from, 0).UTC()
+		hi := m.next(lo)
+		for _, g := range gaps(existing, lo.Unix(), hi.Unix()) {
+			name := m.partitionName(lo)
+			if g.From != lo.Unix() || g.To != hi.Unix() {
+				name = "events_p" + time.Unix(g.From, 0).UTC().Format("20060102")
+			}
+			if err := carvePartition(ctx, conn.Conn(), name, g.From, g.To); err != nil {
+				return created, fmt.Errorf("create %s: %w", name, err)
+			}
+			existing = append(existing, Partition{Name: name, From: g.From, To: g.To})
+			created = append(created, name)
+		}
+	}
+	return created, nil
+}
+
+// Partitions lists the ranged partitions of events (the default partition is
+// not included), oldest first.
+func (db *DB) Partitions(ctx context.Context) ([]Partition, error) {
+	rows, err := db.Pool.Query(ctx, `
+SELECT c.relname, pg_get_expr(c.relpartbound, c.oid)
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = 'events'::regclass`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Partition
+	for rows.Next() {
+		var name, bound string
+		if err := rows.Scan(&name, &bound); err != nil {
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		// bound looks like: FOR VALUES FROM ('1700000000') TO ('1700086400')
+		var p Partition
+		if _, err := fmt.Sscanf(strings.ReplaceAll(bound, "'", ""), "FOR VALUES FROM (%d) TO (%d)", &p.From, &p.To); err != nil {
+			continue // DEFAULT
+		}
+		p.Name = name
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].From < out[j].From })
+	return out, nil
+}
+
+// defaultPeriods returns the period starts of rows sitting in events_default.
+func (m *PartitionManager) defaultPeriods(ctx context.Context, conn *pgx.Conn) ([]int64, error) {
+	unit := "month"
+	if m.interval == PartitionDaily {
+		unit = "day"
+	}
+	rows, err := conn.Query(ctx, `
+SELECT DISTINCT EXTRACT(EPOCH FROM date_trunc('`+unit+`', to_timestamp(ts_epoch) AT TIME ZONE 'UTC'))::bigint
+FROM events_default`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var p int64
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// carvePartition creates name for [from, to), moving any rows for that range
+// out of events_default first (a partition cannot be attached while the
+// default partition holds rows that belong to it).
+func carvePartition(ctx context.Context, conn *pgx.Conn, name string, from, to int64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ident := pgx.Identifier{name}.Sanitize()
+	stmts := []string{
+		"CREATE TABLE " + ident + " (LIKE events INCLUDING DEFAULTS INCLUDING CONSTRAINTS)",
+		fmt.Sprintf(`WITH moved AS (
+  DELETE FROM events_default WHERE ts_epoch >= %d AND ts_epoch < %d RETURNING *
+) INSERT INTO %s SELECT * FROM moved`, from, to, ident),
+		fmt.Sprintf("ALTER TABLE events ATTACH PARTITION %s FOR VALUES FROM (%d) TO (%d)", ident, from, to),
+	}
+	for _, sql := range stmts {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// gaps returns the parts of [from, to) that no existing partition covers,
+// oldest first. A period is partly covered after switching PARTITION_INTERVAL
+// from daily to monthly: the daily partitions keep serving their days and the
+// rest of the month gets a partition of its own, so rows in events_default
+// for those days are still moved out.
+func gaps(parts []Partition, from, to int64) []Partition {
+	sorted := append([]Partition(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+	var out []Partition
+	for _, p := range sorted {
+		if p.To <= from || p.From >= to {
+			continue
+		}
+		if p.From > from {
+			out = append(out, Partition{From: from, To: p.From})
+		}
+		from = max(from, p.To)
+	}
+	if from < to {
+		out = append(out, Partition{From: from, To: to})
+	}
+	return out
+}
+
+func (m *PartitionManager) periodStart(t time.Time) time.Time {
+	if m.interval == PartitionDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (m *PartitionManager) next(t time.Time) time.Time {
+	if m.interval == PartitionDaily {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.AddDate(0, 1, 0)
+}
+
+// partitionName is events_pYYYYMMDD (daily) or events_pYYYYMM (monthly).
+func (m *PartitionManager) partitionName(start time.Time) string {
+	if m.interval == PartitionDaily {
+		return "events_p" + start.Format("20060102")
+	}
+	return "events_p" + start.Format("200601")
+}
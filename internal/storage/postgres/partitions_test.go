@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGaps(t *testing.T) {
+	const day = 86400
+	p := func(from, to int64) Partition { return Partition{From: from * day, To: to * day} }
+	tests := []struct {
+		name  string
+		parts []Partition
+		want  []Partition
+	}{
+		{"empty", nil, []Partition{p(0, 31)}},
+		{"exactly covered", []Partition{p(0, 31)}, nil},
+		{"inside a wider partition", []Partition{p(-10, 40)}, nil},
+		{"outside", []Partition{p(-5, 0), p(31, 40)}, []Partition{p(0, 31)}},
+		// daily partitions left over from before the switch, unsorted
+		{"daily leftovers", []Partition{p(3, 4), p(0, 1), p(1, 2)}, []Partition{p(2, 3), p(4, 31)}},
+		{"overlapping", []Partition{p(0, 10), p(5, 12), p(20, 31)}, []Partition{p(12, 20)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := gaps(tc.parts, 0, 31*day); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("gaps = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// retentionLockKey keeps replicas from purging at the same time.
+const retentionLockKey = int64(0x6576656e74735f72) // "events_r"
+
+// RetentionRule overrides the default TTL for one event_name or channel.
+// TTLDays 0 keeps matching events forever.
+type RetentionRule struct {
+	Field   string `json:"field"` // event_name | channel
+	Value   string `json:"value"`
+	TTLDays int    `json:"ttl_days"`
+}
+
+// RetentionPolicy decides how long events are kept. An event_name rule beats a
+// channel rule, which beats the default.
+type RetentionPolicy struct {
+	DefaultTTLDays int             `json:"default_ttl_days"` // 0 keeps forever
+	Rules          []RetentionRule `json:"rules"`
+}
+
+// ParseRetentionTTL parses "30d", "12w", "2y", a plain day count, or
+// "forever"/"" (0 days).
+func ParseRetentionTTL(s string) (int, error) {
+	orig := s
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "forever" {
+		return 0, nil
+	}
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "d"):
+		s = strings.TrimSuffix(s, "d")
+	case strings.HasSuffix(s, "w"):
+		s, mult = strings.TrimSuffix(s, "w"), 7
+	case strings.HasSuffix(s, "y"):
+		s, mult = strings.TrimSuffix(s, "y"), 365
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid retention %q (want e.g. 30d, 12w, 2y or forever)", orig)
+	}
+	return n * mult, nil
+}
+
+// ParseRetentionRules parses "event_name:login=30d,channel:web=2y".
+func ParseRetentionRules(s string) ([]RetentionRule, error) {
+	var out []RetentionRule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		sel, ttl, ok := strings.Cut(item, "=")
+		field, value, ok2 := strings.Cut(sel, ":")
+		field = strings.TrimSpace(field)
+		if !ok || !ok2 || value == "" || (field != "event_name" && field != "channel") {
+			return nil, fmt.Errorf("invalid retention rule %q (want event_name:<name>=<ttl> or channel:<name>=<ttl>)", item)
+		}
+		days, err := ParseRetentionTTL(ttl)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, RetentionRule{Field: field, Value: strings.TrimSpace(value), TTLDays: days})
+	}
+	return out, nil
+}
+
+// RetentionAction is one purge step (or, in a dry run, what it would remove).
+type RetentionAction struct {
+	Rule   string `json:"rule"`   // default | event_name=<v> | channel=<v> | all
+	Action string `json:"action"` // delete | drop_partition
+	Target string `json:"target"` // events or a partition name
+	Cutoff int64  `json:"cutoff"` // rows with ts_epoch < cutoff
+	Rows   int64  `json:"rows"`
+}
+
+// RetentionReport describes one retention run.
+type RetentionReport struct {
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	DryRun     bool              `json:"dry_run"`
+	Skipped    bool              `json:"skipped,omitempty"` // another instance held the lock
+	Actions    []RetentionAction `json:"actions"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// retentionTarget is the set of rows one rule owns; where uses $1.. and the
+// cutoff is appended as the next parameter.
+type retentionTarget struct {
+	rule   string
+	cutoff int64
+	where  string
+	args   []any
+}
+
+func (p RetentionPolicy) targets(now time.Time) []retentionTarget {
+	cutoff := func(days int) int64 { return now.AddDate(0, 0, -days).Unix() }
+	// empty, not nil: <> ALL(NULL) would match nothing
+	names, channels := []string{}, []string{}
+	for _, r := range p.Rules {
+		if r.Field == "event_name" {
+			names = append(names, r.Value)
+		} else {
+			channels = append(channels, r.Value)
+		}
+	}
+
+	var out []retentionTarget
+	for _, r := range p.Rules {
+		if r.TTLDays == 0 {
+			continue
+		}
+		t := retentionTarget{rule: r.Field + "=" + r.Value, cutoff: cutoff(r.TTLDays)}
+		if r.Field == "event_name" {
+			t.where, t.args = "event_name = $1", []any{r.Value}
+		} else {
+			t.where, t.args = "channel = $1 AND event_name <> ALL($2::text[])", []any{r.Value, names}
+		}
+		out = append(out, t)
+	}
+	if p.DefaultTTLDays > 0 {
+		out = append(out, retentionTarget{
+			rule:   "default",
+			cutoff: cutoff(p.DefaultTTLDays),
+			where:  "event_name <> ALL($1::text[]) AND (channel IS NULL OR channel <> ALL($2::text[]))",
+			args:   []any{names, channels},
+		})
+	}
+	return out
+}
+
+// dropCutoff is the bound below which every event has expired under every
+// rule, so whole partitions can be dropped (0: something is kept forever).
+func (p RetentionPolicy) dropCutoff(now time.Time) int64 {
+	longest := p.DefaultTTLDays
+	if longest == 0 {
+		return 0
+	}
+	for _, r := range p.Rules {
+		if r.TTLDays == 0 {
+			return 0
+		}
+		longest = max(longest, r.TTLDays)
+	}
+	return now.AddDate(0, 0, -longest).Unix()
+}
+
+// RetentionWorker periodically removes expired events: whole partitions when
+// the longest TTL has passed them, chunked deletes otherwise. Each purge is
+// recorded in retention_audit; event_id_keys entries go with their events.
+type RetentionWorker struct {
+	db     *DB
+	policy RetentionPolicy
+	chunk  int
+	dryRun bool
+	now    func() time.Time
+
+	mu   sync.Mutex
+	last *RetentionReport
+}
+
+// NewRetentionWorker deletes at most chunk rows per statement. With dryRun set,
+// scheduled runs only report what they would remove.
+func NewRetentionWorker(db *DB, policy RetentionPolicy, chunk int, dryRun bool) *RetentionWorker {
+	if chunk <= 0 {
+		chunk = 10_000
+	}
+	return &RetentionWorker{db: db, policy: policy, chunk: chunk, dryRun: dryRun, now: time.Now}
+}
+
+func (w *RetentionWorker) Policy() RetentionPolicy { return w.policy }
+
+func (w *RetentionWorker) DryRun() bool { return w.dryRun }
+
+// Last returns the report of the most recent scheduled run, or nil.
+func (w *RetentionWorker) Last() *RetentionReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// Run purges immediately and then every `every` until ctx is done.
+func (w *RetentionWorker) Run(ctx context.Context, every time.Duration) {
+	if every <= 0 {
+		every = time.Hour
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		rep, err := w.Purge(ctx, w.dryRun)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[retention] run failed: %v", err)
+		}
+		for _, a := range rep.Actions {
+			verb := "removed"
+			if rep.DryRun {
+				verb = "would remove"
+			}
+			log.Printf("[retention] %s %d rows (%s %s, rule %s, ts_epoch < %d)", verb, a.Rows, a.Action, a.Target, a.Rule, a.Cutoff)
+		}
+		w.mu.Lock()
+		w.last = &rep
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Purge applies the policy once. In a dry run nothing is deleted and the
+// report holds the row counts that would be removed.
+func (w *RetentionWorker) Purge(ctx context.Context, dryRun bool) (rep RetentionReport, err error) {
+	now := w.now().UTC()
+	rep = RetentionReport{StartedAt: now, DryRun: dryRun, Actions: []RetentionAction{}}
+	defer func() {
+		rep.FinishedAt = w.now().UTC()
+		if err != nil {
+			rep.Error = err.Error()
+		}
+	}()
+
+	conn, err := w.db.Pool.Acquire(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", retentionLockKey).Scan(&locked); err != nil {
+		return rep, fmt.Errorf("advisory lock: %w", err)
+	}
+	if !locked {
+		rep.Skipped = true
+		return rep, nil
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", retentionLockKey)
+	}()
+
+	// UTC days whose rollups still count purged events: the day holding a
+	// cutoff, when rows of that day were actually removed
+	stale := map[int64]bool{}
+	touched := func(cutoff, latest int64) {
+		if day := cutoff / rollupDaily.span * rollupDaily.span; cutoff != day && latest >= day {
+			stale[day] = true
+		}
+	}
+
+	var dropped []string
+	if bound := w.policy.dropCutoff(now); bound > 0 {
+		parts, err := w.db.Partitions(ctx)
+		if err != nil {
+			return rep, err
+		}
+		for _, p := range parts {
+			if p.To > bound {
+				continue
+			}
+			a, err := w.dropPartition(ctx, conn.Conn(), p, bound, dryRun)
+			if err != nil {
+				return rep, fmt.Errorf("drop %s: %w", p.Name, err)
+			}
+			rep.Actions = append(rep.Actions, a)
+			dropped = append(dropped, p.Name)
+			if a.Rows > 0 {
+				touched(bound, p.To-1)
+			}
+		}
+	}
+
+	for _, t := range w.policy.targets(now) {
+		var rows int64
+		if dryRun {
+			rows, err = countExpired(ctx, conn.Conn(), t, dropped)
+		} else {
+			var latest int64
+			rows, latest, err = w.deleteExpired(ctx, conn.Conn(), t)
+			if err == nil {
+				err = w.purgeRollups(ctx, conn.Conn(), t)
+			}
+			if rows > 0 {
+				touched(t.cutoff, latest)
+			}
+		}
+		if err != nil {
+			return rep, fmt.Errorf("rule %s: %w", t.rule, err)
+		}
+		if rows == 0 {
+			continue
+		}
+		a := RetentionAction{Rule: t.rule, Action: "delete", Target: "events", Cutoff: t.cutoff, Rows: rows}
+		if !dryRun {
+			if err := audit(ctx, conn.Conn(), a); err != nil {
+				return rep, err
+			}
+		}
+		rep.Actions = append(rep.Actions, a)
+	}
+
+	// once per day and run: the rebuild locks the rollup tables against ingest
+	for day := range stale {
+		if err := w.db.rebuildRollupDay(ctx, day); err != nil {
+			return rep, fmt.Errorf("rebuild rollups of day %d: %w", day, err)
+		}
+	}
+	return rep, nil
+}
+
+func (w *RetentionWorker) dropPartition(ctx context.Context, conn *pgx.Conn, p Partition, bound int64, dryRun bool) (RetentionAction, error) {
+	a := RetentionAction{Rule: "all", Action: "drop_partition", Target: p.Name, Cutoff: bound}
+	ident := pgx.Identifier{p.Name}.Sanitize()
+	if err := conn.QueryRow(ctx, "SELECT COUNT(*)::bigint FROM "+ident).Scan(&a.Rows); err != nil {
+		return a, err
+	}
+	if dryRun {
+		return a, nil
+	}
+	if _, err := conn.Exec(ctx, "DROP TABLE "+ident); err != nil {
+		return a, err
+	}
+	for {
+		tag, err := conn.Exec(ctx, `
+DELETE FROM event_id_keys WHERE event_id IN (
+  SELECT event_id FROM event_id_keys WHERE ts_epoch >= $1 AND ts_epoch < $2 LIMIT $3
+)`, p.From, p.To, w.chunk)
+		if err != nil {
+			return a, fmt.Errorf("purge event_id_keys: %w", err)
+		}
+		if tag.RowsAffected() < int64(w.chunk) || ctx.Err() != nil {
+			break
+		}
+	}
+	all := retentionTarget{rule: "all", cutoff: bound, where: "TRUE"}
+	if err := w.purgeRollups(ctx, conn, all); err != nil {
+		return a, err
+	}
+	return a, audit(ctx, conn, a)
+}
+
+// purgeRollups removes a target's rollup buckets that end at or before its
+// cutoff.
+// Buckets straddling the cutoff are left to Purge, which rebuilds their day
+// from the rows that remain.
+func (w *RetentionWorker) purgeRollups(ctx context.Context, conn *pgx.Conn, t retentionTarget) error {
+	n := len(t.args)
+	for _, g := range rollupGrains {
+		table := g.countsTable()
+		sql := fmt.Sprintf(`DELETE FROM %s WHERE ctid IN (
+  SELECT ctid FROM %s WHERE %s AND bucket_start + %d <= $%d LIMIT $%d
+)`, table, table, t.where, g.span, n+1, n+2)
+		args := append(append([]any{}, t.args...), t.cutoff, w.chunk)
+		for {
+			tag, err := conn.Exec(ctx, sql, args...)
+			if err != nil {
+				return fmt.Errorf("purge %s: %w", table, err)
+			}
+			if tag.RowsAffected() < int64(w.chunk) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteExpired deletes a target's expired rows chunk by chunk (each chunk
+// commits on its own), dropping their event_id_keys entries alongside. It
+// returns how many rows went and the latest ts_epoch among them.
+func (w *RetentionWorker) deleteExpired(ctx context.Context, conn *pgx.Conn, t retentionTarget) (total, latest int64, err error) {
+	n := len(t.args)
+	sql := fmt.Sprintf(`
+WITH doomed AS (
+  SELECT id, ts_epoch FROM events WHERE %s AND ts_epoch < $%d LIMIT $%d
+), del AS (
+  DELETE FROM events e USING doomed d
+  WHERE e.id = d.id AND e.ts_epoch = d.ts_epoch
+  RETURNING e.event_id, e.ts_epoch
+), keys AS (
+  DELETE FROM event_id_keys k USING del WHERE k.event_id = del.event_id
+)
+SELECT COUNT(*)::bigint, COALESCE(MAX(ts_epoch), 0) FROM del`, t.where, n+1, n+2)
+	args := append(append([]any{}, t.args...), t.cutoff, w.chunk)
+
+	for {
+		var rows, last int64
+		if err := conn.QueryRow(ctx, sql, args...).Scan(&rows, &last); err != nil {
+			return total, latest, err
+		}
+		total += rows
+		latest = max(latest, last)
+		if rows < int64(w.chunk) {
+			return total, latest, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, latest, err
+		}
+	}
+}
+
+// countExpired counts a target's expired rows, leaving out partitions the same
+// dry run already reported as dropped.
+func countExpired(ctx context.Context, conn *pgx.Conn, t retentionTarget, dropped []string) (int64, error) {
+	n := len(t.args)
+	sql := fmt.Sprintf(`SELECT COUNT(*)::bigint FROM events
+WHERE %s AND ts_epoch < $%d AND tableoid::regclass::text <> ALL($%d::text[])`, t.where, n+1, n+2)
+	if dropped == nil {
+		dropped = []string{}
+	}
+	args := append(append([]any{}, t.args...), t.cutoff, dropped)
+	var rows int64
+	err := conn.QueryRow(ctx, sql, args...).Scan(&rows)
+	return rows, err
+}
+
+func audit(ctx context.Context, conn *pgx.Conn, a RetentionAction) error {
+	_, err := conn.Exec(ctx,
+		"INSERT INTO retention_audit (rule, action, target, cutoff, rows) VALUES ($1, $2, $3, $4, $5)",
+		a.Rule, a.Action, a.Target, a.Cutoff, a.Rows)
+	if err != nil {
+		return fmt.Errorf("retention audit: %w", err)
+	}
+	return nil
+}
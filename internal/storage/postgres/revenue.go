@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BaseCurrency is the currency exchange rates are quoted against.
+const BaseCurrency = "USD"
+
+// Revenue is read from these metadata fields of the revenue events.
+const (
+	RevenueAmountField   = "amount"
+	RevenueCurrencyField = "currency"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// IsCurrencyCode reports whether s is an upper-case 3-letter currency code.
+func IsCurrencyCode(s string) bool { return currencyCode.MatchString(s) }
+
+// ErrUnknownCurrency means the reporting currency has no exchange rates.
+var ErrUnknownCurrency = errors.New("no exchange rates for currency")
+
+// ExchangeRate is the value in BaseCurrency of one unit of Currency on the
+// UTC day Day (YYYY-MM-DD).
+type ExchangeRate struct {
+	Day        string  `json:"date"`
+	Currency   string  `json:"currency"`
+	USDPerUnit float64 `json:"usd_per_unit"`
+}
+
+// Normalize upper-cases the currency code and validates the rate.
+func (r *ExchangeRate) Normalize() error {
+	if _, err := time.Parse(time.DateOnly, r.Day); err != nil {
+		return fmt.Errorf("date %q must be YYYY-MM-DD", r.Day)
+	}
+	r.Currency = strings.ToUpper(strings.TrimSpace(r.Currency))
+	if !IsCurrencyCode(r.Currency) {
+		return fmt.Errorf("currency %q must be a 3-letter ISO 4217 code", r.Currency)
+	}
+	if r.Currency == BaseCurrency {
+		return fmt.Errorf("%s is the base currency; its rate is always 1", BaseCurrency)
+	}
+	if !(r.USDPerUnit > 0) || math.IsInf(r.USDPerUnit, 0) {
+		return fmt.Errorf("usd_per_unit must be a positive number")
+	}
+	return nil
+}
+
+// UpsertExchangeRates stores rates (normalized), replacing existing rates for
+// the same currency and day. A later entry for the same key wins.
+func (db *DB) UpsertExchangeRates(ctx context.Context, rates []ExchangeRate) (int64, error) {
+	idx := map[[2]string]int{}
+	var days, currencies []string
+	var values []float64
+	for _, r := range rates {
+		k := [2]string{r.Currency, r.Day}
+		if i, ok := idx[k]; ok {
+			values[i] = r.USDPerUnit
+			continue
+		}
+		idx[k] = len(days)
+		days = append(days, r.Day)
+		currencies = append(currencies, r.Currency)
+		values = append(values, r.USDPerUnit)
+	}
+	tag, err := db.Pool.Exec(ctx, `
+INSERT INTO exchange_rates (day, currency, usd_per_unit)
+SELECT d::date, c, v FROM unnest($1::text[], $2::text[], $3::float8[]) AS t(d, c, v)
+ON CONFLICT (currency, day) DO UPDATE SET usd_per_unit = EXCLUDED.usd_per_unit, updated_at = NOW()`,
+		days, currencies, values)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ExchangeRates lists the stored rates for the UTC days [from, to] (both
+// YYYY-MM-DD), optionally for one currency.
+func (db *DB) ExchangeRates(ctx context.Context, currency, from, to string) ([]ExchangeRate, error) {
+	rows, err := db.Pool.Query(ctx, `
+SELECT to_char(day, 'YYYY-MM-DD'), currency, usd_per_unit::float8
+FROM exchange_rates
+WHERE day BETWEEN $1::date AND $2::date AND ($3 = '' OR currency = $3)
+ORDER BY currency, day`, from, to, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ExchangeRate{}
+	for rows.Next() {
+		var r ExchangeRate
+		if err := rows.Scan(&r.Day, &r.Currency, &r.USDPerUnit); err != nil {
+			return nil, fmt.Errorf("scan exchange rate: %w", err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// RevenueQuery reports revenue of the events selected by MetricsQuery
+// (usually event_name=purchase) in Currency.
+type RevenueQuery struct {
+	MetricsQuery
+	Currency string
+	Dims     []Dimension // optional breakdown
+	Limit    int         // top groups by gross revenue
+}
+
+// RevenueTotals: orders are events with a numeric amount in a currency that
+// could be converted; the others are counted as unconverted.
+type RevenueTotals struct {
+	Gross             float64 `json:"gross_revenue"`
+	Orders            int64   `json:"orders"`
+	AverageOrderValue float64 `json:"average_order_value"`
+	Unconverted       int64   `json:"unconverted_orders"`
+}
+
+type RevenueBucket struct {
+	BucketStart int64
+	RevenueTotals
+}
+
+type RevenueGroup struct {
+	Values  []*string // per dimension; nil = no value
+	Totals  RevenueTotals
+	Buckets []RevenueBucket
+}
+
+type RevenueReport struct {
+	Totals  RevenueTotals
+	Buckets []RevenueBucket
+	Groups  []RevenueGroup // largest gross revenue first
+}
+
+// rateAt is the USD value of one unit of the currency expression cur on the
+// date expression day: the latest rate on or before that day.
+func rateAt(cur, day string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s = '%[3]s' THEN 1::numeric ELSE (
+    SELECT x.usd_per_unit FROM exchange_rates x
+    WHERE x.currency = %[1]s AND x.day <= %[2]s ORDER BY x.day DESC LIMIT 1) END`, cur, day, BaseCurrency)
+}
+
+// QueryRevenue converts each event's metadata amount from its currency to
+// q.Currency at the rates of the event's UTC day (falling back to the latest
+// earlier rate) and sums it into totals, buckets of g and, with q.Dims, the
+// q.Limit groups with the largest gross revenue. Always reads raw events.
+func (db *DB) QueryRevenue(ctx context.Context, q RevenueQuery, g Granularity) (RevenueReport, error) {
+	defer observeQuery("revenue", time.Now())
+	var rep RevenueReport
+
+	if q.Currency != BaseCurrency {
+		var known bool
+		if err := db.Pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM exchange_rates WHERE currency = $1)", q.Currency).Scan(&known); err != nil {
+			return rep, err
+		}
+		if !known {
+			return rep, fmt.Errorf("%w %s", ErrUnknownCurrency, q.Currency)
+		}
+	}
+
+	cond, args := q.where("e.ts_epoch")
+	args = append(args, q.Currency)
+	curArg := fmt.Sprintf("$%d::text", len(args))
+	bucket := "NULL::bigint"
+	if g != "" {
+		args = append(args, q.loc().String())
+		bucket = g.bucketExpr("e.ts_epoch", fmt.Sprintf("$%d", len(args)))
+	}
+
+	// per-bucket rows plus totals (bucket NULL), or totals only
+	bucketCol, overallGroup, groupsGroup := "NULL::bigint", "", "GROUP BY d1, d2"
+	if g != "" {
+		bucketCol = "bucket"
+		overallGroup = "GROUP BY GROUPING SETS ((bucket), ())"
+		groupsGroup = "GROUP BY GROUPING SETS ((d1, d2, bucket), (d1, d2))"
+	}
+	const measures = `COALESCE(ROUND(SUM(value), 2), 0)::float8 AS gross, COUNT(value) AS orders,
+         COALESCE(ROUND(SUM(value) / NULLIF(COUNT(value), 0), 2), 0)::float8 AS aov,
+         COUNT(*) - COUNT(value) AS unconverted`
+
+	sql := fmt.Sprintf(`
+WITH conv AS (
+  SELECT e.event_name, e.channel, e.campaign_id, e.tags, %[2]s AS bucket,
+         CASE WHEN jsonb_typeof(e.metadata -> '%[3]s') = 'number' THEN (e.metadata -> '%[3]s')::numeric END
+           * %[4]s / %[5]s AS value
+  FROM events e %[1]s
+)
+SELECT false AS grouped, NULL::text, NULL::text, %[8]s, %[6]s
+FROM conv %[7]s`,
+		cond, bucket, RevenueAmountField,
+		rateAt(fmt.Sprintf("upper(e.metadata ->> '%s')", RevenueCurrencyField), "(to_timestamp(e.ts_epoch) AT TIME ZONE 'UTC')::date"),
+		rateAt(curArg, "(to_timestamp(e.ts_epoch) AT TIME ZONE 'UTC')::date"),
+		measures, overallGroup, bucketCol)
+
+	if len(q.Dims) > 0 {
+		d1, d2 := rawDimExpr(q.Dims[0]), "''"
+		if len(q.Dims) > 1 {
+			d2 = rawDimExpr(q.Dims[1])
+		}
+		from := "conv e"
+		for _, d := range q.Dims {
+			if d == DimensionTag {
+				from += tagsJoin
+				break
+			}
+		}
+		args = append(args, q.Limit)
+		sql += fmt.Sprintf(`
+UNION ALL (
+WITH grp AS (
+  SELECT d1, d2, %[4]s AS bucket, %[5]s
+  FROM (SELECT %[1]s AS d1, %[2]s AS d2, e.bucket, e.value FROM %[3]s) x
+  %[6]s
+), best AS (
+  SELECT d1, d2 FROM grp WHERE bucket IS NULL ORDER BY gross DESC, d1, d2 LIMIT $%[7]d
+)
+SELECT true, grp.d1, grp.d2, grp.bucket, grp.gross, grp.orders, grp.aov, grp.unconverted
+FROM grp JOIN best ON best.d1 = grp.d1 AND best.d2 = grp.d2)`,
+			d1, d2, from, bucketCol, measures, groupsGroup, len(args))
+	}
+
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+
+	groups := map[[2]string]*RevenueGroup{}
+	var order [][2]string
+	for rows.Next() {
+		var (
+			grouped bool
+			v1, v2  *string
+			start   *int64
+			t       RevenueTotals
+		)
+		if err := rows.Scan(&grouped, &v1, &v2, &start, &t.Gross, &t.Orders, &t.AverageOrderValue, &t.Unconverted); err != nil {
+			return rep, fmt.Errorf("scan revenue: %w", err)
+		}
+		if !grouped {
+			if start == nil {
+				rep.Totals = t
+			} else {
+				rep.Buckets = append(rep.Buckets, RevenueBucket{BucketStart: *start, RevenueTotals: t})
+			}
+			continue
+		}
+		k := [2]string{*v1, *v2}
+		grp, ok := groups[k]
+		if !ok {
+			grp = &RevenueGroup{Values: []*string{dimValue(k[0])}}
+			if len(q.Dims) > 1 {
+				grp.Values = append(grp.Values, dimValue(k[1]))
+			}
+			groups[k] = grp
+			order = append(order, k)
+		}
+		if start == nil {
+			grp.Totals = t
+		} else {
+			grp.Buckets = append(grp.Buckets, RevenueBucket{BucketStart: *start, RevenueTotals: t})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return rep, err
+	}
+
+	fill := func(bs []RevenueBucket) []RevenueBucket {
+		sort.Slice(bs, func(i, j int) bool { return bs[i].BucketStart < bs[j].BucketStart })
+		if g == "" || !q.Fill {
+			return bs
+		}
+		return fillSeries(bs, func(b RevenueBucket) int64 { return b.BucketStart },
+			func(start int64) RevenueBucket { return RevenueBucket{BucketStart: start} }, q.From, q.To, g, q.loc())
+	}
+	rep.Buckets = fill(rep.Buckets)
+	for _, k := range order {
+		grp := groups[k]
+		grp.Buckets = fill(grp.Buckets)
+		rep.Groups = append(rep.Groups, *grp)
+	}
+	sort.SliceStable(rep.Groups, func(i, j int) bool { return rep.Groups[i].Totals.Gross > rep.Groups[j].Totals.Gross })
+	return rep, nil
+}
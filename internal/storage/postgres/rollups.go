@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// rollupGrain is one pre-aggregated granularity: events_rollup_<name> holds
+// counts per (bucket_start, event_name, channel, campaign_id) plus a sketch of
+// each bucket's users. Exact unique users are counted from the raw events.
+type rollupGrain struct {
+	name string // hourly | daily
+	span int64  // bucket width in seconds
+}
+
+var (
+	rollupHourly = rollupGrain{name: "hourly", span: 3600}
+	rollupDaily  = rollupGrain{name: "daily", span: 86400}
+	rollupGrains = []rollupGrain{rollupHourly, rollupDaily}
+)
+
+func (g rollupGrain) countsTable() string { return "events_rollup_" + g.name }
+
+// rollupKey is the bucket/dimension key computed from events columns.
+func (g rollupGrain) rollupKey() string {
+	return fmt.Sprintf("ts_epoch / %d * %d, event_name, COALESCE(channel, ''), COALESCE(campaign_id, '')", g.span, g.span)
+}
+
+// upsertCountsSQL adds the rows of source (events columns) to the counts table.
+// Keys are sorted so concurrent writers lock rollup rows in the same order.
+func (g rollupGrain) upsertCountsSQL(source string) string {
+	t := g.countsTable()
+	return "INSERT INTO " + t + " AS r (bucket_start, event_name, channel, campaign_id, count)\n" +
+		"  SELECT " + g.rollupKey() + ", COUNT(*) FROM " + source + " GROUP BY 1, 2, 3, 4 ORDER BY 1, 2, 3, 4\n" +
+		"  ON CONFLICT (bucket_start, event_name, channel, campaign_id) DO UPDATE SET count = r.count + EXCLUDED.count"
+}
+
+// aligned reports whether rg's buckets exactly cover the inclusive range [from, to].
+func aligned(from, to int64, rg rollupGrain) bool {
+	return to >= from && from%rg.span == 0 && (to+1)%rg.span == 0
+}
+
+// RebuildRollups recomputes the rollups (counts and sketches) for the
+// UTC days overlapping [from, to) from the events table, one transaction per
+// day. The rollup tables are locked against writers for the duration of each
+// day so that batches committing meanwhile are neither lost nor counted twice.
+func (db *DB) RebuildRollups(ctx context.Context, from, to int64, progress func(day int64)) (days int, err error) {
+	for day := from / rollupDaily.span * rollupDaily.span; day < to; day += rollupDaily.span {
+		if err := db.rebuildRollupDay(ctx, day); err != nil {
+			return days, fmt.Errorf("rebuild day %d: %w", day, err)
+		}
+		days++
+		if progress != nil {
+			progress(day)
+		}
+	}
+	return days, nil
+}
+
+func (db *DB) rebuildRollupDay(ctx context.Context, day int64) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// same table order as the writer's statement
+	var tables []string
+	for _, g := range rollupGrains {
+		tables = append(tables, g.countsTable())
+	}
+	if _, err := tx.Exec(ctx, "LOCK TABLE "+strings.Join(tables, ", ")+" IN EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+t+" WHERE bucket_start >= $1 AND bucket_start < $2", day, day+rollupDaily.span); err != nil {
+			return err
+		}
+	}
+	src := fmt.Sprintf("(SELECT * FROM events WHERE ts_epoch >= %d AND ts_epoch < %d) e", day, day+rollupDaily.span)
+	for _, g := range rollupGrains {
+		if _, err := tx.Exec(ctx, g.upsertCountsSQL(src)); err != nil {
+			return err
+		}
+		if err := g.rebuildSketches(ctx, tx, day, day+rollupDaily.span); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
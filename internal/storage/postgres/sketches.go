@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/hll"
+	"github.com/jackc/pgx/v5"
+)
+
+// rollupRow identifies one row of a rollup counts table.
+type rollupRow struct {
+	bucket     int64
+	eventName  string
+	channel    string
+	campaignID string
+}
+
+func (g rollupGrain) row(ev domain.Event) rollupRow {
+	return rollupRow{bucket: ev.Timestamp / g.span * g.span, eventName: ev.EventName, channel: ev.Channel, campaignID: ev.CampaignID}
+}
+
+// rowSketch is the sketch for one rollup row plus how many events of the
+// current batch it covers.
+type rowSketch struct {
+	sketch *hll.Sketch
+	events int64
+}
+
+// mergeSketches folds the users of the inserted events into the users_hll of
+// their rollup rows. It runs in the insert's transaction, after the counts
+// upsert, so the rows exist and are already locked by this transaction.
+func mergeSketches(ctx context.Context, tx pgx.Tx, items []domain.Event, inserted []bool) error {
+	for _, g := range rollupGrains {
+		sk := map[rollupRow]*rowSketch{}
+		for i, ev := range items {
+			if !inserted[i] {
+				continue
+			}
+			k := g.row(ev)
+			rs, ok := sk[k]
+			if !ok {
+				rs = &rowSketch{sketch: hll.New(hll.Precision)}
+				sk[k] = rs
+			}
+			rs.sketch.AddString(ev.UserID)
+			rs.events++
+		}
+		if len(sk) == 0 {
+			continue
+		}
+		if err := g.storeSketches(ctx, tx, sk, true); err != nil {
+			return fmt.Errorf("%s sketches: %w", g.name, err)
+		}
+	}
+	return nil
+}
+
+// matchRow joins a rollup table r to an unnest() of rowArrays u(b, e, c, k).
+const matchRow = "r.bucket_start = u.b AND r.event_name = u.e AND r.channel = u.c AND r.campaign_id = u.k"
+
+// rowArrays turns rollup rows into the four key arrays matched by matchRow.
+func rowArrays(keys []rollupRow) []any {
+	buckets := make([]int64, len(keys))
+	names := make([]string, len(keys))
+	channels := make([]string, len(keys))
+	campaigns := make([]string, len(keys))
+	for i, k := range keys {
+		buckets[i], names[i], channels[i], campaigns[i] = k.bucket, k.eventName, k.channel, k.campaignID
+	}
+	return []any{buckets, names, channels, campaigns}
+}
+
+// storeSketches writes sk to the counts table. With merge set, existing
+// sketches are folded in first; a row that had events before this batch but
+// no sketch (pre-sketch data) is left NULL rather than given a partial sketch.
+func (g rollupGrain) storeSketches(ctx context.Context, tx pgx.Tx, sk map[rollupRow]*rowSketch, merge bool) error {
+	table := g.countsTable()
+	keys := make([]rollupRow, 0, len(sk))
+	for k := range sk {
+		keys = append(keys, k)
+	}
+
+	if merge {
+		rows, err := tx.Query(ctx, `
+SELECT r.bucket_start, r.event_name, r.channel, r.campaign_id, r.count, r.users_hll
+FROM `+table+` r
+JOIN unnest($1::bigint[], $2::text[], $3::text[], $4::text[]) AS u(b, e, c, k) ON `+matchRow+`
+FOR UPDATE OF r`, rowArrays(keys)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				k     rollupRow
+				count int64
+				blob  []byte
+			)
+			if err := rows.Scan(&k.bucket, &k.eventName, &k.channel, &k.campaignID, &count, &blob); err != nil {
+				return err
+			}
+			rs := sk[k]
+			if rs == nil {
+				continue
+			}
+			if blob == nil {
+				if count > rs.events {
+					delete(sk, k)
+				}
+				continue
+			}
+			var prev hll.Sketch
+			if err := prev.UnmarshalBinary(blob); err != nil {
+				return fmt.Errorf("decode users_hll: %w", err)
+			}
+			if err := rs.sketch.Merge(&prev); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+	}
+
+	keys = keys[:0]
+	blobs := make([][]byte, 0, len(sk))
+	for k, rs := range sk {
+		b, err := rs.sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		blobs = append(blobs, b)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+UPDATE `+table+` r SET users_hll = u.h
+FROM unnest($1::bigint[], $2::text[], $3::text[], $4::text[], $5::bytea[]) AS u(b, e, c, k, h)
+WHERE `+matchRow, append(rowArrays(keys), blobs)...)
+	return err
+}
+
+// rebuildSketches recomputes the sketches of g's rows in [from, to) from the
+// distinct users of the events; used by RebuildRollups inside its locked
+// transaction.
+func (g rollupGrain) rebuildSketches(ctx context.Context, tx pgx.Tx, from, to int64) error {
+	rows, err := tx.Query(ctx, `
+SELECT DISTINCT `+g.rollupKey()+`, user_id
+FROM events
+WHERE ts_epoch >= $1 AND ts_epoch < $2`, from, to)
+	if err != nil {
+		return err
+	}
+	sk := map[rollupRow]*rowSketch{}
+	for rows.Next() {
+		var (
+			k    rollupRow
+			user string
+		)
+		if err := rows.Scan(&k.bucket, &k.eventName, &k.channel, &k.campaignID, &user); err != nil {
+			rows.Close()
+			return err
+		}
+		rs, ok := sk[k]
+		if !ok {
+			rs = &rowSketch{sketch: hll.New(hll.Precision)}
+			sk[k] = rs
+		}
+		rs.sketch.AddString(user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(sk) == 0 {
+		return nil
+	}
+	return g.storeSketches(ctx, tx, sk, false)
+}
@@ -5,99 +5,275 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"example.com/goAssignment1/internal/domain"
+	"github.com/jackc/pgx/v5"
 )
 
+// InsertMode selects how InsertBatch ships rows to Postgres.
+type InsertMode string
+
+const (
+	// InsertModeValues sends one multi-row INSERT ... VALUES with bind parameters.
+	InsertModeValues InsertMode = "values"
+	// InsertModeCopy streams rows with COPY into a temp staging table and then
+	// moves them with the same INSERT ... SELECT as the values mode.
+	InsertModeCopy InsertMode = "copy"
+)
+
+// Postgres caps bind parameters per statement at 65535.
+const maxBindParams = 65535
+
+var insertCols = []string{"event_id", "event_name", "user_id", "ts_epoch", "channel", "campaign_id", "tags", "metadata"}
+
 type Writer struct {
-	db *DB
+	db   *DB
+	mode InsertMode
+}
+
+func NewWriter(db *DB, mode InsertMode) *Writer {
+	if mode != InsertModeCopy {
+		mode = InsertModeValues
+	}
+	return &Writer{db: db, mode: mode}
 }
 
-func NewWriter(db *DB) *Writer { return &Writer{db: db} }
+func (w *Writer) Mode() InsertMode { return w.mode }
+
+// Name, Write and IsTransient make the writer an ingest sink.
+func (w *Writer) Name() string { return "postgres" }
+
+func (w *Writer) Write(ctx context.Context, items []domain.Event) ([]bool, error) {
+	return w.InsertBatch(ctx, items)
+}
+
+func (w *Writer) IsTransient(err error) bool { return IsTransient(err) }
+
+// MaxBatchSize is the largest batch the current mode can write in one call
+// (0 means unbounded).
+func (w *Writer) MaxBatchSize() int {
+	if w.mode == InsertModeValues {
+		return maxBindParams / len(insertCols)
+	}
+	return 0
+}
+
+// insertSQL moves the rows of source (insertCols plus an ord column giving
+// batch order) into events and folds the inserted rows into the rollups, all
+// in one statement. events is partitioned on ts_epoch, so event_id cannot
+// carry a global unique index there; instead the first occurrence of each
+// event_id claims it in event_id_keys and only claimed rows are inserted.
+// Rows without event_id rely on uq_events_composite (which includes ts_epoch).
+func insertSQL(source string) string {
+	cols := strings.Join(insertCols, ",")
+	var b strings.Builder
+	b.WriteString(`
+WITH batch AS (
+  SELECT ord,` + cols + ` FROM ` + source + `
+), firsts AS (
+  SELECT DISTINCT ON (event_id) * FROM batch WHERE event_id IS NOT NULL ORDER BY event_id, ord
+), claimed AS (
+  INSERT INTO event_id_keys (event_id, ts_epoch)
+  SELECT event_id, ts_epoch FROM firsts
+  ON CONFLICT DO NOTHING
+  RETURNING event_id
+), ins AS (
+  INSERT INTO events (` + cols + `)
+  SELECT ` + cols + ` FROM (
+    SELECT * FROM batch WHERE event_id IS NULL
+    UNION ALL
+    SELECT f.* FROM firsts f JOIN claimed c USING (event_id)
+  ) b
+  ORDER BY ord
+  ON CONFLICT DO NOTHING
+  RETURNING event_id, event_name, user_id, ts_epoch, channel, campaign_id
+)`)
+	for _, g := range rollupGrains {
+		fmt.Fprintf(&b, ", rollup_%s AS (\n  %s\n)", g.name, g.upsertCountsSQL("ins"))
+	}
+	b.WriteString("\nSELECT event_id, event_name, user_id, ts_epoch FROM ins")
+	return b.String()
+}
 
 // InsertBatch inserts events with ON CONFLICT DO NOTHING to enforce idempotency.
-func (w *Writer) InsertBatch(ctx context.Context, items []domain.Event) (int64, error) {
+// inserted[i] reports whether items[i] created a row (false = duplicate).
+func (w *Writer) InsertBatch(ctx context.Context, items []domain.Event) (inserted []bool, err error) {
 	if len(items) == 0 {
-		return 0, nil
+		return nil, nil
 	}
+	start := time.Now()
+	defer func() { observeInsert(w.mode, start, inserted, err) }()
+	if w.mode == InsertModeCopy {
+		return w.insertCopy(ctx, items)
+	}
+	return w.insertValues(ctx, items)
+}
 
-	cols := []string{"event_id", "event_name", "user_id", "ts_epoch", "channel", "campaign_id", "tags", "metadata"}
+func (w *Writer) insertValues(ctx context.Context, items []domain.Event) ([]bool, error) {
 	placeholders := make([]string, 0, len(items))
-	args := make([]any, 0, len(items)*len(cols))
+	args := make([]any, 0, len(items)*len(insertCols))
 
 	argi := 1
-	for _, ev := range items {
-		ph := make([]string, 0, len(cols))
-
-		// event_id (NULL if empty)
-		if ev.EventID == "" {
-			args = append(args, nil)
-		} else {
-			args = append(args, ev.EventID)
-		}
-		ph = append(ph, fmt.Sprintf("$%d", argi))
-		argi++
-
-		// requireds
-		args = append(args, ev.EventName)
-		ph = append(ph, fmt.Sprintf("$%d", argi))
-		argi++
-
-		args = append(args, ev.UserID)
-		ph = append(ph, fmt.Sprintf("$%d", argi))
-		argi++
-
-		args = append(args, ev.Timestamp)
-		ph = append(ph, fmt.Sprintf("$%d", argi))
-		argi++
-
-		// optionals
-		if ev.Channel == "" {
-			args = append(args, nil)
-		} else {
-			args = append(args, ev.Channel)
-		}
-		ph = append(ph, fmt.Sprintf("$%d", argi))
-		argi++
+	for i, ev := range items {
+		args = append(args, rowValues(ev)...)
+		placeholders = append(placeholders, fmt.Sprintf("(%d,$%d::text,$%d::text,$%d::text,$%d::bigint,$%d::text,$%d::text,$%d::jsonb,$%d::jsonb)",
+			i, argi, argi+1, argi+2, argi+3, argi+4, argi+5, argi+6, argi+7))
+		argi += len(insertCols)
+	}
+
+	source := "(VALUES " + strings.Join(placeholders, ",") + ") AS v(ord," + strings.Join(insertCols, ",") + ")"
+	sql := insertSQL(source)
+
+	tx, err := w.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return commitInserted(ctx, tx, rows, items)
+}
 
-		if ev.CampaignID == "" {
-			args = append(args, nil)
-		} else {
-			args = append(args, ev.CampaignID)
+// stagingDDL creates the per-connection staging table used by the COPY path.
+// ON COMMIT DELETE ROWS keeps it empty between batches on pooled connections.
+const stagingDDL = `
+CREATE TEMP TABLE IF NOT EXISTS events_staging (
+    ord          INT NOT NULL,
+    event_id     TEXT NULL,
+    event_name   TEXT NOT NULL,
+    user_id      TEXT NOT NULL,
+    ts_epoch     BIGINT NOT NULL,
+    channel      TEXT NULL,
+    campaign_id  TEXT NULL,
+    tags         JSONB NULL,
+    metadata     JSONB NULL
+) ON COMMIT DELETE ROWS`
+
+func (w *Writer) insertCopy(ctx context.Context, items []domain.Event) ([]bool, error) {
+	tx, err := w.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, stagingDDL); err != nil {
+		return nil, fmt.Errorf("create staging: %w", err)
+	}
+
+	src := make([][]any, len(items))
+	for i, ev := range items {
+		src[i] = append([]any{i}, rowValues(ev)...)
+	}
+	stagingCols := append([]string{"ord"}, insertCols...)
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"events_staging"}, stagingCols, pgx.CopyFromRows(src)); err != nil {
+		return nil, fmt.Errorf("copy staging: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, insertSQL("events_staging"))
+	if err != nil {
+		return nil, err
+	}
+	return commitInserted(ctx, tx, rows, items)
+}
+
+// commitInserted matches the insert's rows to the batch, folds the inserted
+// users into the rollup sketches and commits.
+func commitInserted(ctx context.Context, tx pgx.Tx, rows pgx.Rows, items []domain.Event) ([]bool, error) {
+	inserted, err := matchInserted(rows, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeSketches(ctx, tx, items, inserted); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// matchInserted maps the rows returned by insertSQL back to batch positions by idempotency key.
+// Rows are inserted in batch order, so when a key repeats inside one batch the
+// first occurrence is the one that was inserted.
+func matchInserted(rows pgx.Rows, items []domain.Event) ([]bool, error) {
+	defer rows.Close()
+	returned := make(map[string]int, len(items))
+	for rows.Next() {
+		var (
+			eventID    *string
+			name, user string
+			ts         int64
+		)
+		if err := rows.Scan(&eventID, &name, &user, &ts); err != nil {
+			return nil, fmt.Errorf("scan inserted: %w", err)
 		}
-		ph = append(ph, fmt.Sprintf("$%d", argi))
-		argi++
-
-		// tags JSONB (nil or JSON string)
-		if len(ev.Tags) == 0 {
-			args = append(args, nil)
-		} else {
-			b, _ := json.Marshal(ev.Tags)
-			args = append(args, string(b))
+		ev := domain.Event{EventName: name, UserID: user, Timestamp: ts}
+		if eventID != nil {
+			ev.EventID = *eventID
 		}
-		ph = append(ph, fmt.Sprintf("$%d::jsonb", argi))
-		argi++
-
-		// metadata JSONB (nil or JSON string)
-		if ev.Metadata == nil {
-			args = append(args, nil)
-		} else {
-			b, _ := json.Marshal(ev.Metadata)
-			args = append(args, string(b))
+		returned[conflictKey(ev)]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	inserted := make([]bool, len(items))
+	for i := range items {
+		k := conflictKey(items[i])
+		if returned[k] > 0 {
+			returned[k]--
+			inserted[i] = true
 		}
-		ph = append(ph, fmt.Sprintf("$%d::jsonb", argi))
-		argi++
+	}
+	return inserted, nil
+}
 
-		placeholders = append(placeholders, "("+strings.Join(ph, ",")+")")
+// conflictKey mirrors the unique indexes: event_id when present, otherwise
+// (event_name, user_id, ts_epoch).
+func conflictKey(ev domain.Event) string {
+	if ev.EventID != "" {
+		return "id\x00" + ev.EventID
 	}
+	return fmt.Sprintf("c\x00%s\x00%s\x00%d", ev.EventName, ev.UserID, ev.Timestamp)
+}
 
-	sql := "INSERT INTO events (" + strings.Join(cols, ",") + ") VALUES " +
-		strings.Join(placeholders, ",") +
-		" ON CONFLICT DO NOTHING"
+// rowValues returns an event's column values in insertCols order.
+// Empty optionals become NULL; tags/metadata are raw JSON text (or NULL).
+func rowValues(ev domain.Event) []any {
+	vals := make([]any, 0, len(insertCols))
 
-	ct, err := w.db.Pool.Exec(ctx, sql, args...)
-	if err != nil {
-		return 0, err
+	if ev.EventID == "" {
+		vals = append(vals, nil)
+	} else {
+		vals = append(vals, ev.EventID)
+	}
+	vals = append(vals, ev.EventName, ev.UserID, ev.Timestamp)
+	if ev.Channel == "" {
+		vals = append(vals, nil)
+	} else {
+		vals = append(vals, ev.Channel)
+	}
+	if ev.CampaignID == "" {
+		vals = append(vals, nil)
+	} else {
+		vals = append(vals, ev.CampaignID)
+	}
+
+	if len(ev.Tags) == 0 {
+		vals = append(vals, nil)
+	} else {
+		b, _ := json.Marshal(ev.Tags)
+		vals = append(vals, string(b))
+	}
+	if ev.Metadata == nil {
+		vals = append(vals, nil)
+	} else {
+		b, _ := json.Marshal(ev.Metadata)
+		vals = append(vals, string(b))
 	}
-	return ct.RowsAffected(), nil
+	return vals
 }
@@ -0,0 +1,322 @@
+// Package telemetry is a small, dependency-free metrics registry that renders
+// the Prometheus text exposition format (version 0.0.4).
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the process-wide registry the instrumented packages use.
+var Default = NewRegistry()
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	names   map[string]struct{}
+	metrics []collector
+	gauges  map[string]*gaugeFunc
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{}), gauges: make(map[string]*gaugeFunc)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.names[name]; dup {
+		panic("telemetry: duplicate metric " + name)
+	}
+	r.names[name] = struct{}{}
+	r.metrics = append(r.metrics, c)
+}
+
+// WriteText renders every metric in the Prometheus text format.
+func (r *Registry) WriteText(out io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]collector(nil), r.metrics...)
+	r.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, m := range metrics {
+		m.write(w)
+	}
+	return w.Flush()
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// --- counters ---
+
+// CounterVec is a family of monotonically increasing counters keyed by label values.
+type CounterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	children   map[string]*Counter
+}
+
+type Counter struct {
+	labelValues []string
+	mu          sync.Mutex
+	v           float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, children: make(map[string]*Counter)}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the child for the given label values (in declaration order).
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("telemetry: %s wants %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.children[key]
+	if !ok {
+		ch = &Counter{labelValues: append([]string(nil), labelValues...)}
+		c.children[key] = ch
+	}
+	return ch
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	for _, ch := range c.sortedChildren() {
+		ch.mu.Lock()
+		v := ch.v
+		ch.mu.Unlock()
+		writeSample(w, c.name, c.labels, ch.labelValues, "", "", v)
+	}
+}
+
+func (c *CounterVec) sortedChildren() []*Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.children))
+	for k := range c.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*Counter, len(keys))
+	for i, k := range keys {
+		out[i] = c.children[k]
+	}
+	return out
+}
+
+// --- gauges ---
+
+type gaugeFunc struct {
+	name, help string
+	mu         sync.Mutex
+	fn         func() float64
+}
+
+// SetGaugeFunc registers (or replaces) a gauge whose value is read at scrape time.
+func (r *Registry) SetGaugeFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	g, ok := r.gauges[name]
+	r.mu.Unlock()
+	if ok {
+		g.mu.Lock()
+		g.fn = fn
+		g.mu.Unlock()
+		return
+	}
+	g = &gaugeFunc{name: name, help: help, fn: fn}
+	r.register(name, g)
+	r.mu.Lock()
+	r.gauges[name] = g
+	r.mu.Unlock()
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.mu.Lock()
+	fn := g.fn
+	g.mu.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, nil, nil, "", "", fn())
+}
+
+// --- histograms ---
+
+// HistogramVec is a family of cumulative histograms keyed by label values.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	children   map[string]*Histogram
+}
+
+type Histogram struct {
+	labelValues []string
+	buckets     []float64
+	mu          sync.Mutex
+	counts      []uint64 // per bucket, non-cumulative
+	count       uint64
+	sum         float64
+}
+
+var (
+	// LatencyBuckets suit request and query durations in seconds.
+	LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// SizeBuckets suit batch sizes.
+	SizeBuckets = []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, children: make(map[string]*Histogram)}
+	r.register(name, h)
+	return h
+}
+
+// NewHistogram registers a histogram without labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("telemetry: %s wants %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch, ok := h.children[key]
+	if !ok {
+		ch = &Histogram{labelValues: append([]string(nil), labelValues...), buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+		h.children[key] = ch
+	}
+	return ch
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.children))
+	for k := range h.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	children := make([]*Histogram, len(keys))
+	for i, k := range keys {
+		children[i] = h.children[k]
+	}
+	h.mu.Unlock()
+
+	for _, ch := range children {
+		ch.mu.Lock()
+		counts := append([]uint64(nil), ch.counts...)
+		count, sum := ch.count, ch.sum
+		ch.mu.Unlock()
+
+		var cum uint64
+		for i, ub := range ch.buckets {
+			cum += counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, ch.labelValues, "le", formatFloat(ub), float64(cum))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, ch.labelValues, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, ch.labelValues, "", "", sum)
+		writeSample(w, h.name+"_count", h.labels, ch.labelValues, "", "", float64(count))
+	}
+}
+
+// --- exposition helpers ---
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,105 @@
+package transporthttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+// Active-user report range: the last 30 days by default, at most 90 days.
+const (
+	defaultActiveUsersDays = 30
+	maxActiveUsersDays     = 90
+)
+
+type activeUsersDay struct {
+	Day         string  `json:"day"`
+	DayStart    int64   `json:"day_start"`
+	DAU         int64   `json:"dau"`
+	WAU         int64   `json:"wau"`
+	MAU         int64   `json:"mau"`
+	DAUMAURatio float64 `json:"dau_mau_ratio"`
+}
+
+type activeUsersResp struct {
+	EventName string           `json:"event_name,omitempty"`
+	Channel   string           `json:"channel,omitempty"`
+	TZ        string           `json:"tz"`
+	Days      []activeUsersDay `json:"days"`
+}
+
+// HandleGetActiveUsers reports rolling 1/7/30-day distinct users per day and
+// the DAU/MAU stickiness ratio: GET /metrics/active-users
+func (d *ServerDeps) HandleGetActiveUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	bad := func(detail string) {
+		WriteProblem(w, http.StatusBadRequest, "invalid parameters", detail, nil)
+	}
+
+	tzName := q.Get("tz")
+	if tzName == "" {
+		tzName = "UTC"
+	}
+	loc, err := time.LoadLocation(tzName)
+	if err != nil || tzName == "Local" {
+		bad("tz must be an IANA time zone name such as Europe/Istanbul")
+		return
+	}
+	var from, to int64
+	if q.Get("from") == "" && q.Get("to") == "" {
+		to = d.Now().Unix()
+		from = time.Unix(to, 0).In(loc).AddDate(0, 0, -(defaultActiveUsersDays - 1)).Unix()
+	} else {
+		var detail string
+		if from, to, detail = d.parseRange(q.Get("from"), q.Get("to")); detail != "" {
+			bad(detail)
+			return
+		}
+	}
+	if to < from {
+		bad("from must not be after to")
+		return
+	}
+	// guardrail: cap the number of reported days
+	if last := time.Unix(from, 0).In(loc).AddDate(0, 0, maxActiveUsersDays-1); last.Unix() < to {
+		from = time.Unix(to, 0).In(loc).AddDate(0, 0, -(maxActiveUsersDays - 1)).Unix()
+	}
+
+	mq := spg.MetricsQuery{
+		EventName: strings.TrimSpace(q.Get("event_name")),
+		Channel:   strings.TrimSpace(q.Get("channel")),
+		From:      from,
+		To:        to,
+		TZ:        loc,
+	}
+	log.Printf("[api] GET /metrics/active-users event_name=%q channel=%q from=%d to=%d tz=%s", mq.EventName, mq.Channel, from, to, loc)
+
+	days, err := d.DB.QueryActiveUsers(r.Context(), mq)
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+		return
+	}
+	resp := activeUsersResp{EventName: mq.EventName, Channel: mq.Channel, TZ: loc.String(), Days: []activeUsersDay{}}
+	for _, day := range days {
+		resp.Days = append(resp.Days, activeUsersDay{
+			Day:         day.Day,
+			DayStart:    day.Start,
+			DAU:         day.DAU,
+			WAU:         day.WAU,
+			MAU:         day.MAU,
+			DAUMAURatio: ratio(day.DAU, day.MAU),
+		})
+	}
+	log.Printf("[api] ACTIVE USERS result: days=%d", len(resp.Days))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,85 @@
+package transporthttp
+
+import (
+	"sync"
+	"time"
+)
+
+// ResultCache is a bounded in-memory cache of computed query results.
+// Entries expire after ttl and the oldest are evicted beyond maxEntries.
+// A nil *ResultCache caches nothing.
+type ResultCache[V any] struct {
+	mu         sync.Mutex
+	byKey      map[string]cacheEntry[V]
+	order      []string // insertion order, for eviction
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+type cacheEntry[V any] struct {
+	val V
+	at  time.Time
+}
+
+func NewResultCache[V any](ttl time.Duration, maxEntries int) *ResultCache[V] {
+	return &ResultCache[V]{
+		byKey:      make(map[string]cacheEntry[V]),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Get returns the value cached under key and when it was stored.
+func (c *ResultCache[V]) Get(key string) (V, time.Time, bool) {
+	var zero V
+	if c == nil {
+		return zero, time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byKey[key]
+	if !ok || c.now().Sub(e.at) > c.ttl {
+		return zero, time.Time{}, false
+	}
+	return e.val, e.at, true
+}
+
+// Put stores val under key and returns the time it was stored.
+func (c *ResultCache[V]) Put(key string, val V) time.Time {
+	if c == nil {
+		return time.Now().UTC()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.ttl <= 0 || c.maxEntries <= 0 {
+		return now
+	}
+	if _, ok := c.byKey[key]; ok {
+		// move key to the back of the eviction order
+		for i, k := range c.order {
+			if k == key {
+				c.order = append(c.order[:i], c.order[i+1:]...)
+				break
+			}
+		}
+	}
+	c.byKey[key] = cacheEntry[V]{val: val, at: now}
+	c.order = append(c.order, key)
+
+	drop := 0
+	for drop < len(c.order) {
+		old := c.byKey[c.order[drop]]
+		if len(c.order)-drop <= c.maxEntries && now.Sub(old.at) <= c.ttl {
+			break
+		}
+		delete(c.byKey, c.order[drop])
+		drop++
+	}
+	if drop > 0 {
+		c.order = append(c.order[:0], c.order[drop:]...)
+	}
+	return now
+}
@@ -0,0 +1,163 @@
+package transporthttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+// Cohort request limits.
+const (
+	defaultCohortPeriods = 7
+	maxCohortPeriods     = 90
+	maxCohortRange       = int64(366 * 24 * 60 * 60)
+)
+
+type cohortPeriod struct {
+	Period int     `json:"period"`
+	Users  int64   `json:"users"`
+	Rate   float64 `json:"rate"`
+}
+
+type cohortRow struct {
+	CohortStart     int64          `json:"cohort_start"`
+	CohortStartTime string         `json:"cohort_start_time"`
+	Users           int64          `json:"users"`
+	Retention       []cohortPeriod `json:"retention"`
+}
+
+type cohortsResp struct {
+	CohortEvent string      `json:"cohort_event"`
+	ReturnEvent string      `json:"return_event"`
+	Granularity string      `json:"granularity"`
+	Period      string      `json:"period"`
+	Periods     int         `json:"periods"`
+	From        int64       `json:"from"`
+	To          int64       `json:"to"`
+	TZ          string      `json:"tz"`
+	Cached      bool        `json:"cached"`
+	ComputedAt  time.Time   `json:"computed_at"`
+	Cohorts     []cohortRow `json:"cohorts"`
+}
+
+// HandleGetCohorts reports the retention matrix of users grouped by their
+// first cohort event: GET /analytics/cohorts
+func (d *ServerDeps) HandleGetCohorts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	prob := map[string][]string{}
+
+	cq := spg.CohortQuery{
+		CohortEvent: strings.TrimSpace(q.Get("cohort_event")),
+		ReturnEvent: strings.TrimSpace(q.Get("return_event")),
+		Granularity: spg.GranularityWeek,
+		Period:      spg.GranularityDay,
+		Periods:     defaultCohortPeriods,
+	}
+	if cq.CohortEvent == "" {
+		prob["cohort_event"] = append(prob["cohort_event"], "is required")
+	}
+	if cq.ReturnEvent == "" {
+		cq.ReturnEvent = cq.CohortEvent
+	}
+	for name, g := range map[string]*spg.Granularity{"granularity": &cq.Granularity, "period": &cq.Period} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		pg, ok := spg.ParseGranularity(v)
+		if !ok || (pg != spg.GranularityDay && pg != spg.GranularityWeek && pg != spg.GranularityMonth) {
+			prob[name] = append(prob[name], "must be one of day, week, month")
+			continue
+		}
+		*g = pg
+	}
+	if v := q.Get("periods"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCohortPeriods {
+			prob["periods"] = append(prob["periods"], "must be between 1 and "+strconv.Itoa(maxCohortPeriods))
+		}
+		cq.Periods = n
+	}
+	tzName := q.Get("tz")
+	if tzName == "" {
+		tzName = "UTC"
+	}
+	loc, err := time.LoadLocation(tzName)
+	if err != nil || tzName == "Local" {
+		prob["tz"] = append(prob["tz"], "must be an IANA time zone name such as Europe/Istanbul")
+	}
+	cq.TZ = loc
+	if q.Get("from") == "" || q.Get("to") == "" {
+		prob["from"] = append(prob["from"], "from and to are required epoch seconds")
+	} else if from, to, detail := d.parseRange(q.Get("from"), q.Get("to")); detail != "" {
+		prob["from"] = append(prob["from"], detail)
+	} else if to < from {
+		prob["from"] = append(prob["from"], "from must not be after to")
+	} else if to-from > maxCohortRange {
+		prob["to"] = append(prob["to"], "range must not exceed 366 days")
+	} else {
+		cq.From, cq.To = from, to
+	}
+	if len(prob) > 0 {
+		WriteProblem(w, http.StatusBadRequest, "validation failed", "one or more parameters are invalid", prob)
+		return
+	}
+	cq = cq.Aligned()
+	log.Printf("[api] GET /analytics/cohorts cohort_event=%q return_event=%q granularity=%s period=%s periods=%d from=%d to=%d tz=%s", cq.CohortEvent, cq.ReturnEvent, cq.Granularity, cq.Period, cq.Periods, cq.From, cq.To, loc)
+
+	key := fmt.Sprintf("%q|%q|%s|%s|%d|%d|%d|%s", cq.CohortEvent, cq.ReturnEvent, cq.Granularity, cq.Period, cq.Periods, cq.From, cq.To, loc)
+	cohorts, at, cached := d.CohortCache.Get(key)
+	if !cached {
+		cohorts, err = d.DB.QueryCohorts(r.Context(), cq)
+		if err != nil {
+			WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+			return
+		}
+		at = d.CohortCache.Put(key, cohorts)
+	}
+
+	resp := cohortsResp{
+		CohortEvent: cq.CohortEvent,
+		ReturnEvent: cq.ReturnEvent,
+		Granularity: string(cq.Granularity),
+		Period:      string(cq.Period),
+		Periods:     cq.Periods,
+		From:        cq.From,
+		To:          cq.To,
+		TZ:          loc.String(),
+		Cached:      cached,
+		ComputedAt:  at,
+		Cohorts:     []cohortRow{},
+	}
+	now := d.Now().Unix()
+	for _, c := range cohorts {
+		row := cohortRow{
+			CohortStart:     c.Start,
+			CohortStartTime: time.Unix(c.Start, 0).In(loc).Format(time.RFC3339),
+			Users:           c.Users,
+			Retention:       []cohortPeriod{},
+		}
+		// periods that have not begun yet would read as zero retention
+		for k, n := range c.Returned {
+			if !cq.PeriodStarted(c, k, now) {
+				break
+			}
+			row.Retention = append(row.Retention, cohortPeriod{Period: k, Users: n, Rate: ratio(n, c.Users)})
+		}
+		resp.Cohorts = append(resp.Cohorts, row)
+	}
+	log.Printf("[api] COHORTS result: cohorts=%d cached=%t", len(resp.Cohorts), cached)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
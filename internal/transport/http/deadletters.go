@@ -0,0 +1,107 @@
+package transporthttp
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"example.com/goAssignment1/internal/domain"
+	"example.com/goAssignment1/internal/ingest"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+type deadLettersResp struct {
+	Items       []domain.DeadLetter `json:"items"`
+	NextAfterID int64               `json:"next_after_id,omitempty"`
+}
+
+// HandleListDeadLetters pages through parked events: GET /dead-letters?after_id=&limit=
+func (d *ServerDeps) HandleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	var afterID int64
+	if s := q.Get("after_id"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "after_id must be a non-negative integer", nil)
+			return
+		}
+		afterID = n
+	}
+	limit := defaultDeadLetterLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxDeadLetterLimit {
+			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "limit must be between 1 and "+strconv.Itoa(maxDeadLetterLimit), nil)
+			return
+		}
+		limit = n
+	}
+
+	items, err := d.DB.ListDeadLetters(r.Context(), afterID, limit)
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+		return
+	}
+	resp := deadLettersResp{Items: items}
+	if resp.Items == nil {
+		resp.Items = []domain.DeadLetter{}
+	}
+	if len(items) == limit {
+		resp.NextAfterID = items[len(items)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type redriveReq struct {
+	IDs []int64 `json:"ids"`
+}
+
+// HandleRedriveDeadLetters re-submits parked events to the ingestor and removes
+// them from the dead-letter table: POST /dead-letters/redrive {"ids":[...]}
+func (d *ServerDeps) HandleRedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	defer DrainBody(r)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req redriveReq
+	if err := decodeJSONStrict(r, &req); err != nil {
+		WriteProblem(w, http.StatusBadRequest, "invalid json", err.Error(), nil)
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxDeadLetterLimit {
+		WriteProblem(w, http.StatusBadRequest, "invalid parameters", "ids: between 1 and "+strconv.Itoa(maxDeadLetterLimit)+" items", nil)
+		return
+	}
+
+	ticket, n, err := d.Ingestor.RedriveDeadLetters(r.Context(), d.DB, req.IDs)
+	if errors.Is(err, ingest.ErrQueueFull) || errors.Is(err, ingest.ErrStopped) {
+		log.Printf("[api] dead-letter redrive aborted: %v", err)
+		writeEnqueueProblem(w, err)
+		return
+	}
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "redrive error", err.Error(), nil)
+		return
+	}
+	log.Printf("[api] redriven %d dead-lettered events", n)
+
+	body := `{"redriven_count":` + strconv.Itoa(n)
+	if ticket != nil {
+		body += `,"receipt_id":"` + ticket.ReceiptID() + `"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte(body + `}`))
+}
@@ -0,0 +1,134 @@
+package transporthttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+// Funnel request limits; the time range is capped like /metrics.
+const (
+	maxFunnelSteps      = 10
+	defaultFunnelWindow = int64(7 * 24 * 60 * 60)
+	maxFunnelWindow     = int64(90 * 24 * 60 * 60)
+)
+
+type funnelStepReq struct {
+	EventName string   `json:"event_name"`
+	Channel   string   `json:"channel,omitempty"`
+	Filters   []string `json:"filters,omitempty"`
+}
+
+type funnelReq struct {
+	Steps         []funnelStepReq `json:"steps"`
+	From          int64           `json:"from"`
+	To            int64           `json:"to"`
+	WindowSeconds int64           `json:"window_seconds,omitempty"`
+}
+
+type funnelStepResp struct {
+	EventName string `json:"event_name"`
+	Users     int64  `json:"users"`
+	// ConversionRate is relative to the first step, StepConversionRate to
+	// the previous one.
+	ConversionRate            float64  `json:"conversion_rate"`
+	StepConversionRate        float64  `json:"step_conversion_rate"`
+	MedianSecondsFromPrevious *float64 `json:"median_seconds_from_previous"`
+}
+
+type funnelResp struct {
+	From          int64            `json:"from"`
+	To            int64            `json:"to"`
+	WindowSeconds int64            `json:"window_seconds"`
+	Steps         []funnelStepResp `json:"steps"`
+}
+
+// HandlePostFunnel computes an ordered conversion funnel: POST /analytics/funnels
+func (d *ServerDeps) HandlePostFunnel(w http.ResponseWriter, r *http.Request) {
+	defer DrainBody(r)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req funnelReq
+	if err := decodeJSONStrict(r, &req); err != nil {
+		WriteProblem(w, http.StatusBadRequest, "invalid json", err.Error(), nil)
+		return
+	}
+	if req.WindowSeconds == 0 {
+		req.WindowSeconds = defaultFunnelWindow
+	}
+
+	prob := map[string][]string{}
+	if len(req.Steps) < 2 || len(req.Steps) > maxFunnelSteps {
+		prob["steps"] = append(prob["steps"], "must hold 2 to "+strconv.Itoa(maxFunnelSteps)+" steps")
+	}
+	if req.From <= 0 || req.To < req.From {
+		prob["from"] = append(prob["from"], "from and to are required epoch seconds with from <= to")
+	} else if req.To-req.From > maxWindowSeconds {
+		prob["to"] = append(prob["to"], "range must not exceed 90 days")
+	}
+	if req.WindowSeconds < 1 || req.WindowSeconds > maxFunnelWindow {
+		prob["window_seconds"] = append(prob["window_seconds"], "must be between 1 second and 90 days")
+	}
+	q := spg.FunnelQuery{From: req.From, To: req.To, Window: req.WindowSeconds}
+	for i, st := range req.Steps {
+		k := "steps[" + strconv.Itoa(i) + "]"
+		step := spg.FunnelStep{EventName: strings.TrimSpace(st.EventName), Channel: strings.TrimSpace(st.Channel)}
+		if step.EventName == "" {
+			prob[k+".event_name"] = append(prob[k+".event_name"], "is required")
+		}
+		if len(st.Filters) > maxFilters {
+			prob[k+".filters"] = append(prob[k+".filters"], "at most "+strconv.Itoa(maxFilters)+" filters")
+		}
+		for _, fs := range st.Filters {
+			f, err := spg.ParseFilter(fs)
+			if err != nil {
+				prob[k+".filters"] = append(prob[k+".filters"], err.Error())
+				continue
+			}
+			step.Filters = append(step.Filters, f)
+		}
+		q.Steps = append(q.Steps, step)
+	}
+	if len(prob) > 0 {
+		WriteProblem(w, http.StatusBadRequest, "validation failed", "one or more fields are invalid", prob)
+		return
+	}
+	log.Printf("[api] POST /analytics/funnels steps=%d from=%d to=%d window=%ds", len(q.Steps), q.From, q.To, q.Window)
+
+	res, err := d.DB.QueryFunnel(r.Context(), q)
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+		return
+	}
+	resp := funnelResp{From: q.From, To: q.To, WindowSeconds: q.Window}
+	for i, sr := range res {
+		st := funnelStepResp{
+			EventName:                 q.Steps[i].EventName,
+			Users:                     sr.Users,
+			MedianSecondsFromPrevious: sr.MedianSecondsFromPrevious,
+		}
+		st.ConversionRate = ratio(sr.Users, res[0].Users)
+		st.StepConversionRate = st.ConversionRate
+		if i > 0 {
+			st.StepConversionRate = ratio(sr.Users, res[i-1].Users)
+		}
+		resp.Steps = append(resp.Steps, st)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ratio is n/of, or 0 when of is 0.
+func ratio(n, of int64) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
@@ -1,7 +1,9 @@
 package transporthttp
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -18,10 +20,14 @@ import (
 )
 
 type ServerDeps struct {
-	Cfg      config.Config
-	Ingestor *ingest.Ingestor
-	DB       *spg.DB
-	Now      func() time.Time
+	Cfg       config.Config
+	Ingestor  *ingest.Ingestor
+	DB        *spg.DB
+	Retention *spg.RetentionWorker
+	Now       func() time.Time
+
+	// CohortCache holds computed retention matrices; nil disables caching.
+	CohortCache *ResultCache[[]spg.Cohort]
 }
 
 func decodeJSONStrict(r *http.Request, v any) error {
@@ -70,15 +76,137 @@ func (d *ServerDeps) HandlePostEvent(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _ = idempotency.DeriveKey(&ev)
 
-	if ok := d.Ingestor.Enqueue(ev); !ok {
-		WriteProblem(w, http.StatusServiceUnavailable, "overloaded", "ingest queue is full, please retry", nil)
+	ticket, err := d.Ingestor.Enqueue(ev)
+	if err != nil {
+		writeEnqueueProblem(w, err)
 		return
 	}
 	log.Printf("[api] queued 1 event: name=%s user=%s ts=%d", ev.EventName, ev.UserID, ev.Timestamp)
 
+	if wantsCommit(r) {
+		d.writeCommitted(w, r, ticket)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	_, _ = w.Write([]byte(`{"status":"queued"}`))
+	_, _ = w.Write([]byte(`{"status":"queued","receipt_id":"` + ticket.ReceiptID() + `"}`))
+}
+
+func writeEnqueueProblem(w http.ResponseWriter, err error) {
+	if errors.Is(err, ingest.ErrQueueFull) {
+		WriteProblem(w, http.StatusServiceUnavailable, "overloaded", "ingest queue is full, please retry", nil)
+		return
+	}
+	if errors.Is(err, ingest.ErrStopped) {
+		w.Header().Set("Retry-After", "5")
+		WriteProblem(w, http.StatusServiceUnavailable, "shutting down", "server is shutting down, please retry", nil)
+		return
+	}
+	log.Printf("[api] enqueue failed: %v", err)
+	WriteProblem(w, http.StatusInternalServerError, "ingest error", "event could not be durably queued, please retry", nil)
+}
+
+// --- Wait-for-commit mode ---
+
+// wantsCommit reports whether the client opted into synchronous ingestion via
+// "?wait=commit" or "X-Ingest-Wait: commit".
+func wantsCommit(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("wait"), "commit") ||
+		strings.EqualFold(r.Header.Get("X-Ingest-Wait"), "commit")
+}
+
+type eventResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type committedResp struct {
+	ReceiptID      string        `json:"receipt_id"`
+	InsertedCount  int           `json:"inserted_count"`
+	DuplicateCount int           `json:"duplicate_count"`
+	Results        []eventResult `json:"results"`
+}
+
+// writeCommitted blocks until the ticket's batch(es) are processed and answers
+// 201 with per-event results, 503 if the write failed transiently or timed out
+// (events stay queued; retrying is safe thanks to idempotency), and 500 if any
+// event was permanently rejected.
+func (d *ServerDeps) writeCommitted(w http.ResponseWriter, r *http.Request, ticket *ingest.Ticket) {
+	ctx, cancel := context.WithTimeout(r.Context(), d.Cfg.SyncWaitTimeout)
+	defer cancel()
+	outcomes, err := ticket.Wait(ctx)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		WriteProblem(w, http.StatusServiceUnavailable, "commit timeout", "events are queued but were not committed in time; retrying is safe", nil)
+		return
+	}
+
+	resp := committedResp{ReceiptID: ticket.ReceiptID()}
+	var transient, permanent bool
+	errs := map[string][]string{}
+	for i, o := range outcomes {
+		res := eventResult{Index: i, Status: string(o.Status)}
+		switch o.Status {
+		case ingest.StatusInserted:
+			resp.InsertedCount++
+		case ingest.StatusDuplicate:
+			resp.DuplicateCount++
+		case ingest.StatusFailed:
+			transient = true
+		case ingest.StatusDeadLettered:
+			permanent = true
+		}
+		if o.Err != nil {
+			res.Error = o.Err.Error()
+			k := "events[" + strconv.Itoa(i) + "]"
+			errs[k] = append(errs[k], string(o.Status)+": "+o.Err.Error())
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	switch {
+	case transient:
+		w.Header().Set("Retry-After", "1")
+		WriteProblem(w, http.StatusServiceUnavailable, "write failed", "database write failed; events are kept and will be retried", errs)
+		return
+	case permanent:
+		WriteProblem(w, http.StatusInternalServerError, "write failed", "one or more events were rejected by the database and dead-lettered", errs)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// --- Receipts ---
+
+// HandleGetReceipt reports the ingestion state of a previous request: GET /ingest/receipts/{id}
+func (d *ServerDeps) HandleGetReceipt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rc, ok := d.Ingestor.Receipts().Get(r.PathValue("id"))
+	if !ok {
+		WriteProblem(w, http.StatusNotFound, "not found", "unknown or expired receipt", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rc)
+}
+
+// --- Ops ---
+
+// HandleIngestStats exposes queue, WAL and per-worker counters: GET /ops/ingest
+func (d *ServerDeps) HandleIngestStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d.Ingestor.Stats())
 }
 
 // --- Events (bulk) ---
@@ -116,130 +244,214 @@ func (d *ServerDeps) HandlePostEventsBulk(w http.ResponseWriter, r *http.Request
 		WriteProblem(w, http.StatusBadRequest, "validation failed", top.Error(), prob)
 		return
 	}
-	for _, ev := range br.Events {
-		if ok := d.Ingestor.Enqueue(ev); !ok {
-			WriteProblem(w, http.StatusServiceUnavailable, "overloaded", "ingest queue is full, please retry", nil)
-			return
-		}
+	ticket, err := d.Ingestor.EnqueueMany(br.Events)
+	if err != nil {
+		writeEnqueueProblem(w, err)
+		return
 	}
 	log.Printf("[api] queued %d events (bulk)", len(br.Events))
 
+	if wantsCommit(r) {
+		d.writeCommitted(w, r, ticket)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	_, _ = w.Write([]byte(`{"accepted_count":` + strconv.Itoa(len(br.Events)) + `}`))
+	_, _ = w.Write([]byte(`{"accepted_count":` + strconv.Itoa(len(br.Events)) + `,"receipt_id":"` + ticket.ReceiptID() + `"}`))
 }
 
 // --- Metrics ---
 
 type metricsTotals struct {
-	Count       int64 `json:"count"`
-	UniqueUsers int64 `json:"unique_users"`
+	Count       int64    `json:"count"`
+	UniqueUsers int64    `json:"unique_users"`
+	Value       *float64 `json:"value,omitempty"` // aggregate; absent without numeric values
 }
 type metricsBucket struct {
-	BucketStart int64 `json:"bucket_start"`
-	Count       int64 `json:"count"`
-	UniqueUsers int64 `json:"unique_users"`
+	BucketStart     int64    `json:"bucket_start"`
+	BucketStartTime string   `json:"bucket_start_time"` // RFC3339 in the requested tz
+	Count           int64    `json:"count"`
+	UniqueUsers     int64    `json:"unique_users"`
+	Value           *float64 `json:"value,omitempty"`
+}
+
+// metricsGroup is one dimension combination; a nil value means events
+// without that attribute. The "other" group merges the groups past the limit.
+type metricsGroup struct {
+	Dimensions   map[string]*string `json:"dimensions,omitempty"`
+	Other        bool               `json:"other,omitempty"`
+	MergedGroups int                `json:"merged_groups,omitempty"`
+	Totals       metricsTotals      `json:"totals"`
+	Buckets      []metricsBucket    `json:"buckets,omitempty"`
 }
 type metricsResp struct {
-	Totals  metricsTotals   `json:"totals"`
-	Buckets []metricsBucket `json:"buckets"`
+	TZ         string          `json:"tz"`
+	Totals     metricsTotals   `json:"totals"`
+	Buckets    []metricsBucket `json:"buckets"`
+	Dimensions []string        `json:"dimensions,omitempty"`
+	Groups     []metricsGroup  `json:"groups,omitempty"`
+	Aggregate  string          `json:"aggregate,omitempty"`
+	// Accuracy of unique_users: "exact", or "approx" with the relative
+	// standard error of the estimate.
+	Accuracy         string  `json:"accuracy"`
+	UniqueUsersError float64 `json:"unique_users_error,omitempty"`
 }
 
 const defaultWindowSeconds = int64(24 * 60 * 60)  // 24h
 const maxWindowSeconds = int64(90 * 24 * 60 * 60) // 90d cap
 
+// maxBuckets caps the series length: 90 days of hours. Finer granularities get
+// a shorter window (minute: 36h), coarser ones the full 90 days.
+const maxBuckets = int64(90 * 24)
+
+// Top-N limits for dimension breakdowns.
+const (
+	defaultGroupLimit = 10
+	maxGroupLimit     = 100
+)
+
+// maxFilters caps the filter parameters of one /metrics request.
+const maxFilters = 10
+
+func maxWindowFor(g spg.Granularity) int64 {
+	if g == "" {
+		return maxWindowSeconds
+	}
+	return min(maxWindowSeconds, maxBuckets*g.Span())
+}
+
 func (d *ServerDeps) HandleGetMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
+	p, ok := d.parseMetricsParams(w, r)
+	if !ok {
+		return
+	}
 	q := r.URL.Query()
-	eventName := strings.TrimSpace(q.Get("event_name")) // optional
-	fromStr := q.Get("from")                            // optional
-	toStr := q.Get("to")                                // optional
-	groupBy := q.Get("group_by")
-	channel := strings.TrimSpace(q.Get("channel"))
-
-	now := d.Now().Unix()
-	var from, to int64
-	var err error
-
-	switch {
-	case fromStr == "" && toStr == "":
-		from, to = now-defaultWindowSeconds, now
-	case fromStr != "" && toStr == "":
-		from, err = strconv.ParseInt(fromStr, 10, 64)
-		if err != nil {
-			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "from must be epoch seconds", nil)
-			return
-		}
-		to = now
-	case fromStr == "" && toStr != "":
-		to, err = strconv.ParseInt(toStr, 10, 64)
-		if err != nil {
-			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "to must be epoch seconds", nil)
-			return
-		}
-		from = to - defaultWindowSeconds
-	default:
-		from, err = strconv.ParseInt(fromStr, 10, 64)
-		if err != nil {
-			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "from must be epoch seconds", nil)
-			return
-		}
-		to, err = strconv.ParseInt(toStr, 10, 64)
-		if err != nil {
-			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "to must be epoch seconds", nil)
+	accuracy := q.Get("accuracy")
+	if accuracy == "" {
+		accuracy = "exact"
+	}
+	if accuracy != "exact" && accuracy != "approx" {
+		WriteProblem(w, http.StatusBadRequest, "invalid parameters", "accuracy must be exact or approx", nil)
+		return
+	}
+	var agg *spg.Aggregate
+	if s := q.Get("aggregate"); s != "" {
+		a, aggErr := spg.ParseAggregate(s)
+		if aggErr != nil {
+			WriteProblem(w, http.StatusBadRequest, "invalid parameters", "aggregate must look like sum:metadata.amount",
+				map[string][]string{"aggregate": {aggErr.Error()}})
 			return
 		}
+		agg = &a
 	}
+	mq, gran, dims, loc := p.Query, p.Gran, p.Dims, p.Query.TZ
+	mq.Aggregate = agg
 
-	// guardrail: cap large ranges
-	if to-from > maxWindowSeconds {
-		from = to - maxWindowSeconds
+	if agg != nil {
+		// sketches carry no metadata; aggregates always scan raw events
+		accuracy = "exact"
+	}
+	source := string(mq.Source(gran))
+	if accuracy == "approx" {
+		source = "sketches"
 	}
+	log.Printf("[api] GET /metrics event_name=%q channel=%q from=%d to=%d group_by=%q tz=%s dimensions=%v filters=%v source=%s", mq.EventName, mq.Channel, mq.From, mq.To, gran, loc, dims, mq.Filters, source)
 
-	// optional filters
-	var evPtr *string
-	if eventName != "" {
-		evPtr = &eventName
+	ctx := r.Context()
+	resp := metricsResp{TZ: loc.String(), Accuracy: accuracy}
+	if agg != nil {
+		resp.Aggregate = agg.String()
 	}
-	var chPtr *string
-	if channel != "" {
-		chPtr = &channel
+	if accuracy == "approx" {
+		resp.UniqueUsersError = spg.UniqueUsersRelError
 	}
 
-	log.Printf("[api] GET /metrics event_name=%q channel=%q from=%d to=%d group_by=%q", eventName, channel, from, to, groupBy)
-
-	ctx := r.Context()
-	tot, err := d.DB.QueryTotals(ctx, evPtr, from, to, chPtr)
+	tot, bs, err := d.queryMetrics(ctx, mq, gran, &resp)
 	if err != nil {
 		WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
 		return
 	}
+	resp.Totals = metricsTotals{Count: tot.Count, UniqueUsers: tot.UniqueUsers, Value: tot.Value}
 
-	var resp metricsResp
-	resp.Totals = metricsTotals{Count: tot.Count, UniqueUsers: tot.UniqueUsers}
+	if gran != "" {
+		resp.Buckets = toMetricsBuckets(bs, loc)
+		log.Printf("[api] METRICS result: totals={count:%d uniq:%d} buckets=%d accuracy=%s", tot.Count, tot.UniqueUsers, len(resp.Buckets), resp.Accuracy)
+	} else {
+		log.Printf("[api] METRICS result: totals={count:%d uniq:%d} accuracy=%s", tot.Count, tot.UniqueUsers, resp.Accuracy)
+	}
 
-	if groupBy == "day" {
-		bs, err := d.DB.QueryBucketsDaily(ctx, evPtr, from, to, chPtr)
+	if len(dims) > 0 {
+		// breakdowns are always exact
+		gs, err := d.DB.QueryGroups(ctx, mq, dims, p.Limit, gran)
 		if err != nil {
 			WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
 			return
 		}
-		for _, b := range bs {
-			resp.Buckets = append(resp.Buckets, metricsBucket{BucketStart: b.BucketStart, Count: b.Count, UniqueUsers: b.UniqueUsers})
+		for _, dim := range dims {
+			resp.Dimensions = append(resp.Dimensions, string(dim))
 		}
-		log.Printf("[api] METRICS result: totals={count:%d uniq:%d} buckets=%d", tot.Count, tot.UniqueUsers, len(resp.Buckets))
-	} else {
-		log.Printf("[api] METRICS result: totals={count:%d uniq:%d}", tot.Count, tot.UniqueUsers)
+		for _, g := range gs {
+			mg := metricsGroup{
+				Other:        g.Other,
+				MergedGroups: g.Merged,
+				Totals:       metricsTotals{Count: g.Totals.Count, UniqueUsers: g.Totals.UniqueUsers, Value: g.Totals.Value},
+				Buckets:      toMetricsBuckets(g.Buckets, loc),
+			}
+			if !g.Other {
+				mg.Dimensions = map[string]*string{}
+				for i, dim := range dims {
+					mg.Dimensions[string(dim)] = g.Values[i]
+				}
+			}
+			resp.Groups = append(resp.Groups, mg)
+		}
+		log.Printf("[api] METRICS result: groups=%d", len(resp.Groups))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+func toMetricsBuckets(bs []spg.MetricsBucket, loc *time.Location) []metricsBucket {
+	var out []metricsBucket
+	for _, b := range bs {
+		out = append(out, metricsBucket{
+			BucketStart:     b.BucketStart,
+			BucketStartTime: time.Unix(b.BucketStart, 0).In(loc).Format(time.RFC3339),
+			Count:           b.Count,
+			UniqueUsers:     b.UniqueUsers,
+			Value:           b.Value,
+		})
+	}
+	return out
+}
+
+// queryMetrics answers totals and (for a non-empty g) buckets. When resp asks
+// for approx, both come from sketches; if the range has unsketched rollups or
+// no usable rollups at all, both are counted exactly instead and resp says so,
+// so accuracy always describes the whole response.
+func (d *ServerDeps) queryMetrics(ctx context.Context, mq spg.MetricsQuery, g spg.Granularity, resp *metricsResp) (spg.MetricsTotals, []spg.MetricsBucket, error) {
+	if resp.Accuracy == "approx" {
+		tot, bs, err := d.DB.QueryApprox(ctx, mq, g)
+		if !errors.Is(err, spg.ErrSketchesMissing) && !errors.Is(err, spg.ErrNoRollups) {
+			return tot, bs, err
+		}
+		resp.Accuracy, resp.UniqueUsersError = "exact", 0
+	}
+	tot, err := d.DB.QueryTotals(ctx, mq)
+	if err != nil || g == "" {
+		return tot, nil, err
+	}
+	bs, err := d.DB.QueryBuckets(ctx, mq, g)
+	return tot, bs, err
+}
+
 // --- Serve OpenAPI ---
 
 func (d *ServerDeps) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
@@ -274,5 +486,59 @@ func (d *ServerDeps) Router() http.Handler {
 	getMetrics = APIKeyAuth(d.Cfg.APIKeys)(getMetrics)
 	mux.Handle("/metrics", getMetrics)
 
-	return mux
+	var getRevenue http.Handler = http.HandlerFunc(d.HandleGetRevenue)
+	getRevenue = RateLimitPerMinute(d.Cfg.RateLimitMetricsPerMin, d.Now)(getRevenue)
+	getRevenue = APIKeyAuth(d.Cfg.APIKeys)(getRevenue)
+	mux.Handle("/metrics/revenue", getRevenue)
+
+	var getActive http.Handler = http.HandlerFunc(d.HandleGetActiveUsers)
+	getActive = RateLimitPerMinute(d.Cfg.RateLimitMetricsPerMin, d.Now)(getActive)
+	getActive = APIKeyAuth(d.Cfg.APIKeys)(getActive)
+	mux.Handle("/metrics/active-users", getActive)
+
+	var funnels http.Handler = http.HandlerFunc(d.HandlePostFunnel)
+	funnels = BodyLimit(d.Cfg.MaxBodyBytes)(funnels)
+	funnels = RequireJSON(funnels)
+	funnels = RateLimitPerMinute(d.Cfg.RateLimitMetricsPerMin, d.Now)(funnels)
+	funnels = APIKeyAuth(d.Cfg.APIKeys)(funnels)
+	mux.Handle("/analytics/funnels", funnels)
+
+	var cohorts http.Handler = http.HandlerFunc(d.HandleGetCohorts)
+	cohorts = RateLimitPerMinute(d.Cfg.RateLimitMetricsPerMin, d.Now)(cohorts)
+	cohorts = APIKeyAuth(d.Cfg.APIKeys)(cohorts)
+	mux.Handle("/analytics/cohorts", cohorts)
+
+	var getReceipt http.Handler = http.HandlerFunc(d.HandleGetReceipt)
+	getReceipt = APIKeyAuth(d.Cfg.APIKeys)(getReceipt)
+	mux.Handle("/ingest/receipts/{id}", getReceipt)
+
+	var ingestStats http.Handler = http.HandlerFunc(d.HandleIngestStats)
+	ingestStats = APIKeyAuth(d.Cfg.APIKeys)(ingestStats)
+	mux.Handle("/ops/ingest", ingestStats)
+
+	var retention http.Handler = http.HandlerFunc(d.HandleRetentionStatus)
+	retention = APIKeyAuth(d.Cfg.APIKeys)(retention)
+	mux.Handle("/ops/retention", retention)
+
+	var retentionDryRun http.Handler = http.HandlerFunc(d.HandleRetentionDryRun)
+	retentionDryRun = APIKeyAuth(d.Cfg.APIKeys)(retentionDryRun)
+	mux.Handle("/ops/retention/dry-run", retentionDryRun)
+
+	var rates http.Handler = http.HandlerFunc(d.HandleExchangeRates)
+	rates = BodyLimit(d.Cfg.MaxBodyBytes)(rates)
+	rates = RequireJSON(rates)
+	rates = APIKeyAuth(d.Cfg.APIKeys)(rates)
+	mux.Handle("/admin/exchange-rates", rates)
+
+	var listDL http.Handler = http.HandlerFunc(d.HandleListDeadLetters)
+	listDL = APIKeyAuth(d.Cfg.APIKeys)(listDL)
+	mux.Handle("/dead-letters", listDL)
+
+	var redriveDL http.Handler = http.HandlerFunc(d.HandleRedriveDeadLetters)
+	redriveDL = BodyLimit(d.Cfg.MaxBodyBytes)(redriveDL)
+	redriveDL = RequireJSON(redriveDL)
+	redriveDL = APIKeyAuth(d.Cfg.APIKeys)(redriveDL)
+	mux.Handle("/dead-letters/redrive", redriveDL)
+
+	return Instrument(mux)
 }
@@ -0,0 +1,125 @@
+package transporthttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+// metricsParams are the query parameters shared by /metrics and the reports
+// built on the same filters.
+type metricsParams struct {
+	Query spg.MetricsQuery
+	Gran  spg.Granularity
+	Dims  []spg.Dimension
+	Limit int
+}
+
+// parseMetricsParams reads event_name, channel, from, to, group_by, tz, fill,
+// filter, dimensions and limit. On invalid input it writes a 400 problem and
+// returns false.
+func (d *ServerDeps) parseMetricsParams(w http.ResponseWriter, r *http.Request) (metricsParams, bool) {
+	q := r.URL.Query()
+	p := metricsParams{Limit: defaultGroupLimit}
+	bad := func(detail string) (metricsParams, bool) {
+		WriteProblem(w, http.StatusBadRequest, "invalid parameters", detail, nil)
+		return metricsParams{}, false
+	}
+
+	if groupBy := q.Get("group_by"); groupBy != "" {
+		var ok bool
+		if p.Gran, ok = spg.ParseGranularity(groupBy); !ok {
+			return bad("group_by must be one of minute, hour, day, week, month")
+		}
+	}
+	tzName := q.Get("tz")
+	if tzName == "" {
+		tzName = "UTC"
+	}
+	loc, err := time.LoadLocation(tzName)
+	if err != nil || tzName == "Local" {
+		return bad("tz must be an IANA time zone name such as Europe/Istanbul")
+	}
+	fill := q.Get("fill")
+	if fill == "" {
+		fill = "zero"
+	}
+	if fill != "zero" && fill != "none" {
+		return bad("fill must be zero or none")
+	}
+	if len(q["filter"]) > maxFilters {
+		return bad("at most " + strconv.Itoa(maxFilters) + " filters")
+	}
+	var filters []spg.Filter
+	for _, fs := range q["filter"] {
+		f, err := spg.ParseFilter(fs)
+		if err != nil {
+			return bad("filter: " + err.Error())
+		}
+		filters = append(filters, f)
+	}
+	if s := q.Get("dimensions"); s != "" {
+		if p.Dims, err = spg.ParseDimensions(s); err != nil {
+			return bad("dimensions: " + err.Error())
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxGroupLimit {
+			return bad("limit must be between 1 and " + strconv.Itoa(maxGroupLimit))
+		}
+		p.Limit = n
+	}
+
+	from, to, detail := d.parseRange(q.Get("from"), q.Get("to"))
+	if detail != "" {
+		return bad(detail)
+	}
+	// guardrail: cap large ranges
+	if window := maxWindowFor(p.Gran); to-from > window {
+		from = to - window
+	}
+
+	p.Query = spg.MetricsQuery{
+		EventName: strings.TrimSpace(q.Get("event_name")),
+		Channel:   strings.TrimSpace(q.Get("channel")),
+		From:      from,
+		To:        to,
+		TZ:        loc,
+		Fill:      fill == "zero",
+		Filters:   filters,
+	}
+	return p, true
+}
+
+// parseRange resolves the optional from/to epoch seconds: either bound
+// defaults to 24h from the other, both to the 24h ending now. detail is the
+// problem to report when one is invalid.
+func (d *ServerDeps) parseRange(fromStr, toStr string) (from, to int64, detail string) {
+	now := d.Now().Unix()
+	var err error
+	switch {
+	case fromStr == "" && toStr == "":
+		return now - defaultWindowSeconds, now, ""
+	case fromStr != "" && toStr == "":
+		if from, err = strconv.ParseInt(fromStr, 10, 64); err != nil {
+			return 0, 0, "from must be epoch seconds"
+		}
+		return from, now, ""
+	case fromStr == "" && toStr != "":
+		if to, err = strconv.ParseInt(toStr, 10, 64); err != nil {
+			return 0, 0, "to must be epoch seconds"
+		}
+		return to - defaultWindowSeconds, to, ""
+	}
+	if from, err = strconv.ParseInt(fromStr, 10, 64); err != nil {
+		return 0, 0, "from must be epoch seconds"
+	}
+	if to, err = strconv.ParseInt(toStr, 10, 64); err != nil {
+		return 0, 0, "to must be epoch seconds"
+	}
+	return from, to, ""
+}
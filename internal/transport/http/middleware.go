@@ -3,8 +3,21 @@ package transporthttp
 import (
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"example.com/goAssignment1/internal/telemetry"
+)
+
+var (
+	mHTTPRequests = telemetry.Default.NewCounterVec("events_api_http_requests_total",
+		"HTTP requests by route pattern, method and status code.", "route", "method", "code")
+	mHTTPSeconds = telemetry.Default.NewHistogramVec("events_api_http_request_duration_seconds",
+		"HTTP request latency by route pattern.", telemetry.LatencyBuckets, "route")
+	mRateLimited = telemetry.Default.NewCounterVec("events_api_http_rate_limited_total",
+		"Requests rejected by the rate limiter.", "route")
 )
 
 // BodyLimit limits request bodies to maxBytes.
@@ -49,8 +62,10 @@ func APIKeyAuth(allowed map[string]struct{}) func(http.Handler) http.Handler {
 	}
 }
 
-// Simple global leaky bucket for GET /metrics (20 req/min by default).
+// Simple leaky bucket per wrapped route, for the expensive read endpoints
+// (/metrics*, /analytics/*; 20 req/min by default).
 type rateState struct {
+	mu             sync.Mutex
 	tokens         float64
 	lastRefillNano int64
 }
@@ -65,11 +80,8 @@ func RateLimitPerMinute(limitPerMin int, clock func() time.Time) func(http.Handl
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/metrics") {
-				next.ServeHTTP(w, r)
-				return
-			}
 			now := clock()
+			state.mu.Lock()
 			elapsed := float64(now.UnixNano()-state.lastRefillNano) / 1e9
 			state.lastRefillNano = now.UnixNano()
 
@@ -77,17 +89,54 @@ func RateLimitPerMinute(limitPerMin int, clock func() time.Time) func(http.Handl
 			if state.tokens > capacity {
 				state.tokens = capacity
 			}
-			if state.tokens < 1.0 {
+			limited := state.tokens < 1.0
+			if !limited {
+				state.tokens -= 1.0
+			}
+			state.mu.Unlock()
+			if limited {
+				mRateLimited.With(r.Pattern).Inc()
 				w.Header().Set("Retry-After", "3")
 				WriteProblem(w, http.StatusTooManyRequests, "rate limit exceeded", "try again later", nil)
 				return
 			}
-			state.tokens -= 1.0
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// Instrument counts requests and their latency per matched route pattern.
+// It must wrap the ServeMux itself so that r.Pattern is populated.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		mHTTPRequests.With(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		mHTTPSeconds.With(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
 // DrainBody fully reads and closes request bodies (handler helper).
 func DrainBody(r *http.Request) {
 	if r.Body != nil {
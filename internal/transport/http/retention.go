@@ -0,0 +1,53 @@
+package transporthttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+type retentionStatusResp struct {
+	Policy  spg.RetentionPolicy  `json:"policy"`
+	DryRun  bool                 `json:"dry_run"`
+	LastRun *spg.RetentionReport `json:"last_run"`
+}
+
+// HandleRetentionStatus shows the retention policy and the last scheduled run: GET /ops/retention
+func (d *ServerDeps) HandleRetentionStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	resp := retentionStatusResp{
+		Policy:  d.Retention.Policy(),
+		DryRun:  d.Retention.DryRun(),
+		LastRun: d.Retention.Last(),
+	}
+	if resp.Policy.Rules == nil {
+		resp.Policy.Rules = []spg.RetentionRule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// HandleRetentionDryRun reports what a retention run would remove right now,
+// without deleting anything: POST /ops/retention/dry-run
+func (d *ServerDeps) HandleRetentionDryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rep, err := d.Retention.Purge(r.Context(), true)
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+		return
+	}
+	if rep.Skipped {
+		w.Header().Set("Retry-After", "60")
+		WriteProblem(w, http.StatusConflict, "retention busy", "a retention run is in progress", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,177 @@
+package transporthttp
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	spg "example.com/goAssignment1/internal/storage/postgres"
+)
+
+// defaultRevenueEvent is the event revenue is read from when no event_name is given.
+const defaultRevenueEvent = "purchase"
+
+// maxRatesPerRequest caps one exchange-rate upload.
+const maxRatesPerRequest = 10000
+
+type revenueBucket struct {
+	BucketStart     int64  `json:"bucket_start"`
+	BucketStartTime string `json:"bucket_start_time"`
+	spg.RevenueTotals
+}
+type revenueGroup struct {
+	Dimensions map[string]*string `json:"dimensions"`
+	Totals     spg.RevenueTotals  `json:"totals"`
+	Buckets    []revenueBucket    `json:"buckets,omitempty"`
+}
+type revenueResp struct {
+	TZ         string            `json:"tz"`
+	Currency   string            `json:"currency"`
+	EventName  string            `json:"event_name"`
+	Totals     spg.RevenueTotals `json:"totals"`
+	Buckets    []revenueBucket   `json:"buckets"`
+	Dimensions []string          `json:"dimensions,omitempty"`
+	Groups     []revenueGroup    `json:"groups,omitempty"`
+}
+
+// HandleGetRevenue reports gross revenue, orders and average order value in
+// one currency: GET /metrics/revenue
+func (d *ServerDeps) HandleGetRevenue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := d.parseMetricsParams(w, r)
+	if !ok {
+		return
+	}
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		currency = spg.BaseCurrency
+	}
+	if !spg.IsCurrencyCode(currency) {
+		WriteProblem(w, http.StatusBadRequest, "invalid parameters", "currency must be a 3-letter ISO 4217 code", nil)
+		return
+	}
+	if p.Query.EventName == "" {
+		p.Query.EventName = defaultRevenueEvent
+	}
+	rq := spg.RevenueQuery{MetricsQuery: p.Query, Currency: currency, Dims: p.Dims, Limit: p.Limit}
+	loc := p.Query.TZ
+	log.Printf("[api] GET /metrics/revenue event_name=%q currency=%s from=%d to=%d group_by=%q tz=%s dimensions=%v filters=%v", rq.EventName, currency, rq.From, rq.To, p.Gran, loc, p.Dims, rq.Filters)
+
+	rep, err := d.DB.QueryRevenue(r.Context(), rq, p.Gran)
+	if errors.Is(err, spg.ErrUnknownCurrency) {
+		WriteProblem(w, http.StatusBadRequest, "invalid parameters", err.Error(), nil)
+		return
+	}
+	if err != nil {
+		WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+		return
+	}
+
+	toBuckets := func(bs []spg.RevenueBucket) []revenueBucket {
+		var out []revenueBucket
+		for _, b := range bs {
+			out = append(out, revenueBucket{
+				BucketStart:     b.BucketStart,
+				BucketStartTime: time.Unix(b.BucketStart, 0).In(loc).Format(time.RFC3339),
+				RevenueTotals:   b.RevenueTotals,
+			})
+		}
+		return out
+	}
+	resp := revenueResp{
+		TZ:        loc.String(),
+		Currency:  currency,
+		EventName: rq.EventName,
+		Totals:    rep.Totals,
+		Buckets:   toBuckets(rep.Buckets),
+	}
+	for _, dim := range p.Dims {
+		resp.Dimensions = append(resp.Dimensions, string(dim))
+	}
+	for _, g := range rep.Groups {
+		rg := revenueGroup{Dimensions: map[string]*string{}, Totals: g.Totals, Buckets: toBuckets(g.Buckets)}
+		for i, dim := range p.Dims {
+			rg.Dimensions[string(dim)] = g.Values[i]
+		}
+		resp.Groups = append(resp.Groups, rg)
+	}
+	log.Printf("[api] REVENUE result: gross=%.2f %s orders=%d unconverted=%d buckets=%d groups=%d", rep.Totals.Gross, currency, rep.Totals.Orders, rep.Totals.Unconverted, len(resp.Buckets), len(resp.Groups))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type exchangeRatesReq struct {
+	Rates []spg.ExchangeRate `json:"rates"`
+}
+
+// HandleExchangeRates lists (GET, optional currency/from/to days) or upserts
+// (POST {"rates": [...]}) the daily exchange rates: /admin/exchange-rates
+func (d *ServerDeps) HandleExchangeRates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		to := q.Get("to")
+		if to == "" {
+			to = d.Now().UTC().Format(time.DateOnly)
+		}
+		from := q.Get("from")
+		if from == "" {
+			from = "0001-01-01"
+		}
+		for _, day := range []string{from, to} {
+			if _, err := time.Parse(time.DateOnly, day); err != nil {
+				WriteProblem(w, http.StatusBadRequest, "invalid parameters", "from and to must be YYYY-MM-DD", nil)
+				return
+			}
+		}
+		rates, err := d.DB.ExchangeRates(r.Context(), strings.ToUpper(q.Get("currency")), from, to)
+		if err != nil {
+			WriteProblem(w, http.StatusInternalServerError, "query error", err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(exchangeRatesReq{Rates: rates})
+
+	case http.MethodPost:
+		defer DrainBody(r)
+		var req exchangeRatesReq
+		if err := decodeJSONStrict(r, &req); err != nil {
+			WriteProblem(w, http.StatusBadRequest, "invalid json", err.Error(), nil)
+			return
+		}
+		if len(req.Rates) == 0 || len(req.Rates) > maxRatesPerRequest {
+			WriteProblem(w, http.StatusBadRequest, "validation failed", "rates must hold 1 to "+strconv.Itoa(maxRatesPerRequest)+" entries", nil)
+			return
+		}
+		prob := map[string][]string{}
+		for i := range req.Rates {
+			if err := req.Rates[i].Normalize(); err != nil {
+				k := "rates[" + strconv.Itoa(i) + "]"
+				prob[k] = append(prob[k], err.Error())
+			}
+		}
+		if len(prob) > 0 {
+			WriteProblem(w, http.StatusBadRequest, "validation failed", "one or more rates are invalid", prob)
+			return
+		}
+		n, err := d.DB.UpsertExchangeRates(r.Context(), req.Rates)
+		if err != nil {
+			WriteProblem(w, http.StatusInternalServerError, "write failed", err.Error(), nil)
+			return
+		}
+		log.Printf("[api] upserted %d exchange rates", n)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"upserted":` + strconv.FormatInt(n, 10) + `}`))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,467 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log is an append-only, segmented write-ahead log.
+// Every record gets a monotonically increasing sequence number. Consumers
+// acknowledge sequences once they are durably stored elsewhere; the log keeps
+// a checkpoint (the highest sequence below which everything is acknowledged)
+// and deletes segments that fall entirely behind it.
+//
+// On-disk layout (Dir):
+//
+//	00000000000000000001.seg   records starting at seq 1
+//	00000000000000004711.seg   records starting at seq 4711 (active)
+//	checkpoint                 decimal seq, written atomically
+//	quarantine/<seq>.rec       payloads the consumer could not process
+//
+// Record framing: [len uint32][crc32c uint32][seq uint64][payload].
+type Log struct {
+	opts Options
+
+	mu         sync.Mutex // guards writes and the segment list
+	active     segmentFile
+	activeSize int64
+	segments   []uint64 // first seq of every segment, ascending (last = active)
+	nextSeq    uint64
+	replayUpTo uint64 // last seq that existed when the log was opened
+	closed     bool
+
+	ackMu      sync.Mutex // guards checkpoint state
+	checkpoint uint64
+	acked      map[uint64]struct{}
+}
+
+// segmentFile is the active segment; *os.File in production.
+type segmentFile interface {
+	io.WriteSeeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+type Options struct {
+	Dir          string
+	SegmentBytes int64 // rotate the active segment once it grows past this size
+	Fsync        bool  // fsync after every append (durable 202s)
+}
+
+const (
+	headerSize     = 16
+	segmentExt     = ".seg"
+	checkpointFile = "checkpoint"
+	quarantineDir  = "quarantine"
+	maxRecordBytes = 16 << 20
+)
+
+var (
+	ErrClosed  = errors.New("wal: closed")
+	errCorrupt = errors.New("wal: corrupt record")
+	crcTable   = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Open loads (or creates) the log in opts.Dir and starts a fresh active segment.
+func Open(opts Options) (*Log, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 64 << 20
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal mkdir: %w", err)
+	}
+	l := &Log{opts: opts, acked: make(map[uint64]struct{})}
+
+	cp, err := l.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	l.checkpoint = cp
+
+	segs, err := l.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	l.nextSeq = cp + 1
+	if n := len(segs); n > 0 {
+		last := segs[n-1]
+		count, err := l.scanSegment(last, nil)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			// never written to; reuse its starting seq for the new segment
+			_ = os.Remove(l.segmentPath(last))
+			segs = segs[:n-1]
+		}
+		if last+count > l.nextSeq {
+			l.nextSeq = last + count
+		}
+	}
+	l.replayUpTo = l.nextSeq - 1
+	l.segments = segs
+
+	if err := l.rotateLocked(); err != nil {
+		return nil, err
+	}
+	l.gcLocked(l.checkpoint)
+	return l, nil
+}
+
+// Append durably writes one payload and returns its sequence number.
+func (l *Log) Append(payload []byte) (uint64, error) {
+	seqs, err := l.AppendBatch([][]byte{payload})
+	if err != nil {
+		return 0, err
+	}
+	return seqs[0], nil
+}
+
+// AppendBatch writes several payloads with a single fsync.
+func (l *Log) AppendBatch(payloads [][]byte) ([]uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if l.activeSize >= l.opts.SegmentBytes {
+		if err := l.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf []byte
+	seqs := make([]uint64, len(payloads))
+	for i, p := range payloads {
+		if len(p) > maxRecordBytes {
+			return nil, fmt.Errorf("wal: record too large (%d bytes)", len(p))
+		}
+		seq := l.nextSeq + uint64(i)
+		seqs[i] = seq
+		buf = appendRecord(buf, seq, p)
+	}
+	if _, err := l.active.Write(buf); err != nil {
+		return nil, l.discardTailLocked(fmt.Errorf("wal write: %w", err))
+	}
+	if l.opts.Fsync {
+		if err := l.active.Sync(); err != nil {
+			return nil, l.discardTailLocked(fmt.Errorf("wal fsync: %w", err))
+		}
+	}
+	l.nextSeq += uint64(len(payloads))
+	l.activeSize += int64(len(buf))
+	return seqs, nil
+}
+
+// Replay calls fn for every record that was unacknowledged when the log was
+// opened, in sequence order. Records appended after Open are not replayed.
+func (l *Log) Replay(fn func(seq uint64, payload []byte) error) error {
+	l.mu.Lock()
+	segs := append([]uint64(nil), l.segments...)
+	upTo := l.replayUpTo
+	l.mu.Unlock()
+
+	from := l.Checkpoint()
+	for _, first := range segs {
+		if first > upTo {
+			break
+		}
+		_, err := l.scanSegment(first, func(seq uint64, payload []byte) error {
+			if seq <= from || seq > upTo {
+				return nil
+			}
+			return fn(seq, payload)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ack marks sequences as durably handled. The checkpoint advances over the
+// contiguous acknowledged prefix and fully-acknowledged segments are removed.
+func (l *Log) Ack(seqs ...uint64) error {
+	l.ackMu.Lock()
+	before := l.checkpoint
+	for _, s := range seqs {
+		if s > l.checkpoint {
+			l.acked[s] = struct{}{}
+		}
+	}
+	for {
+		if _, ok := l.acked[l.checkpoint+1]; !ok {
+			break
+		}
+		delete(l.acked, l.checkpoint+1)
+		l.checkpoint++
+	}
+	cp := l.checkpoint
+	var err error
+	if cp != before {
+		err = l.writeCheckpoint(cp)
+	}
+	l.ackMu.Unlock()
+
+	if cp != before {
+		l.mu.Lock()
+		l.gcLocked(cp)
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// Quarantine durably copies the payload of a record its consumer cannot
+// process to quarantine/<seq>.rec, so that the record can be acknowledged
+// without losing it. Nothing reads the copies back; they are for an operator.
+func (l *Log) Quarantine(seq uint64, payload []byte) error {
+	dir := filepath.Join(l.opts.Dir, quarantineDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("wal quarantine: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%020d.rec", seq))
+	if err := writeFileSync(path, payload); err != nil {
+		return fmt.Errorf("wal quarantine: %w", err)
+	}
+	return nil
+}
+
+// Checkpoint returns the highest sequence below which everything is acknowledged.
+func (l *Log) Checkpoint() uint64 {
+	l.ackMu.Lock()
+	defer l.ackMu.Unlock()
+	return l.checkpoint
+}
+
+// Pending returns how many appended records are not yet acknowledged.
+func (l *Log) Pending() uint64 {
+	l.mu.Lock()
+	next := l.nextSeq
+	l.mu.Unlock()
+	l.ackMu.Lock()
+	defer l.ackMu.Unlock()
+	return next - 1 - l.checkpoint - uint64(len(l.acked))
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.active == nil {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		_ = l.active.Close()
+		return err
+	}
+	return l.active.Close()
+}
+
+// --- internals ---
+
+func appendRecord(buf []byte, seq uint64, payload []byte) []byte {
+	var hdr [headerSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(hdr[8:16], seq)
+	crc := crc32.Update(0, crcTable, hdr[8:16])
+	crc = crc32.Update(crc, crcTable, payload)
+	binary.LittleEndian.PutUint32(hdr[4:8], crc)
+	buf = append(buf, hdr[:]...)
+	return append(buf, payload...)
+}
+
+// scanSegment reads records from one segment until EOF or the first torn/corrupt
+// record (which can only be the tail after a crash). It returns the number of
+// valid records read.
+func (l *Log) scanSegment(first uint64, fn func(seq uint64, payload []byte) error) (uint64, error) {
+	f, err := os.Open(l.segmentPath(first))
+	if err != nil {
+		return 0, fmt.Errorf("wal open segment: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		hdr   [headerSize]byte
+		count uint64
+	)
+	for {
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return count, nil
+			}
+			return count, fmt.Errorf("wal read: %w", err)
+		}
+		n := binary.LittleEndian.Uint32(hdr[0:4])
+		if n > maxRecordBytes {
+			return count, nil
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(f, payload); err != nil {
+			return count, nil
+		}
+		crc := crc32.Update(0, crcTable, hdr[8:16])
+		crc = crc32.Update(crc, crcTable, payload)
+		seq := binary.LittleEndian.Uint64(hdr[8:16])
+		if crc != binary.LittleEndian.Uint32(hdr[4:8]) || seq != first+count {
+			return count, nil
+		}
+		count++
+		if fn != nil {
+			if err := fn(seq, payload); err != nil {
+				return count, err
+			}
+		}
+	}
+}
+
+// discardTailLocked removes whatever part of a failed append reached the
+// active segment, so the next append (which reuses the same seqs) does not
+// land behind a torn record that replay would stop at. If the segment cannot
+// be cut back, it is sealed as is and a fresh one is started: its torn tail
+// is then harmless, as replay moves on to the next segment.
+func (l *Log) discardTailLocked(cause error) error {
+	if err := l.active.Truncate(l.activeSize); err == nil {
+		if _, err := l.active.Seek(l.activeSize, io.SeekStart); err == nil {
+			return cause
+		}
+	}
+	_ = l.active.Close()
+	l.active = nil
+	last := l.segments[len(l.segments)-1]
+	count, _ := l.scanSegment(last, nil)
+	if count == 0 {
+		// nothing valid in it; the new segment takes over its name
+		l.segments = l.segments[:len(l.segments)-1]
+	}
+	if next := last + count; next > l.nextSeq {
+		// records of the failed batch that did reach the segment keep their
+		// seqs; the caller was told they failed, so they count as acknowledged
+		// (like any ack above the checkpoint, this is lost on a crash)
+		l.ackMu.Lock()
+		for s := l.nextSeq; s < next; s++ {
+			l.acked[s] = struct{}{}
+		}
+		l.ackMu.Unlock()
+		l.nextSeq = next
+	}
+	if err := l.rotateLocked(); err != nil {
+		l.closed = true
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+func (l *Log) rotateLocked() error {
+	if l.active != nil {
+		if err := l.active.Sync(); err != nil {
+			return fmt.Errorf("wal fsync: %w", err)
+		}
+		if err := l.active.Close(); err != nil {
+			return fmt.Errorf("wal close segment: %w", err)
+		}
+	}
+	first := l.nextSeq
+	f, err := os.OpenFile(l.segmentPath(first), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("wal create segment: %w", err)
+	}
+	l.active = f
+	l.activeSize = 0
+	l.segments = append(l.segments, first)
+	return nil
+}
+
+// gcLocked removes sealed segments whose records are all <= cp.
+func (l *Log) gcLocked(cp uint64) {
+	keep := 0
+	for keep < len(l.segments)-1 && l.segments[keep+1]-1 <= cp {
+		if err := os.Remove(l.segmentPath(l.segments[keep])); err != nil && !os.IsNotExist(err) {
+			break
+		}
+		keep++
+	}
+	l.segments = l.segments[keep:]
+}
+
+func (l *Log) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(l.opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("wal list: %w", err)
+	}
+	var out []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+func (l *Log) segmentPath(first uint64) string {
+	return filepath.Join(l.opts.Dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+func (l *Log) readCheckpoint() (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(l.opts.Dir, checkpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("wal read checkpoint: %w", err)
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wal parse checkpoint: %w", err)
+	}
+	return n, nil
+}
+
+func (l *Log) writeCheckpoint(cp uint64) error {
+	path := filepath.Join(l.opts.Dir, checkpointFile)
+	if err := writeFileSync(path, []byte(strconv.FormatUint(cp, 10))); err != nil {
+		return fmt.Errorf("wal checkpoint: %w", err)
+	}
+	return nil
+}
+
+// writeFileSync replaces path with b atomically: a synced temp file renamed
+// over it.
+func writeFileSync(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,338 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openLog(t *testing.T, dir string, segmentBytes int64) *Log {
+	t.Helper()
+	l, err := Open(Options{Dir: dir, SegmentBytes: segmentBytes})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return l
+}
+
+func appendN(t *testing.T, l *Log, payloads ...string) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	for _, p := range payloads {
+		seq, err := l.Append([]byte(p))
+		if err != nil {
+			t.Fatalf("append %q: %v", p, err)
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+// replayed returns the records Replay hands out, as "seq:payload".
+func replayed(t *testing.T, l *Log) []string {
+	t.Helper()
+	var out []string
+	err := l.Replay(func(seq uint64, payload []byte) error {
+		out = append(out, fmt.Sprintf("%d:%s", seq, payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return out
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	m, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m {
+		m[i] = filepath.Base(m[i])
+	}
+	return m
+}
+
+func TestReplayAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, 0)
+	appendN(t, l, "a", "b", "c")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, dir, 0)
+	defer l.Close()
+	if got, want := replayed(t, l), []string{"1:a", "2:b", "3:c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replay = %v, want %v", got, want)
+	}
+	if seq := appendN(t, l, "d")[0]; seq != 4 {
+		t.Fatalf("next seq = %d, want 4", seq)
+	}
+	// records appended after Open are not replayed
+	if got := replayed(t, l); len(got) != 3 {
+		t.Fatalf("replay after append = %v", got)
+	}
+}
+
+func TestReplayStopsAtDamagedTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, path string, size int64)
+	}{
+		{"torn record", func(t *testing.T, path string, size int64) {
+			if err := os.Truncate(path, size-1); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"torn header", func(t *testing.T, path string, size int64) {
+			rec := int64(headerSize + 1)
+			if err := os.Truncate(path, size-rec+headerSize/2); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"bad checksum", func(t *testing.T, path string, size int64) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b[size-1] ^= 0xff
+			if err := os.WriteFile(path, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openLog(t, dir, 0)
+			appendN(t, l, "a", "b", "c")
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, segmentFiles(t, dir)[0])
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.damage(t, path, fi.Size())
+
+			l = openLog(t, dir, 0)
+			defer l.Close()
+			if got, want := replayed(t, l), []string{"1:a", "2:b"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("replay = %v, want %v", got, want)
+			}
+			// the damaged record's sequence is reused
+			if seq := appendN(t, l, "d")[0]; seq != 3 {
+				t.Fatalf("next seq = %d, want 3", seq)
+			}
+		})
+	}
+}
+
+func TestReopenAfterCrashMidAppend(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, 0)
+	appendN(t, l, "a", "b")
+	// crash: half a record reaches the disk and the log is never closed
+	torn := appendRecord(nil, 3, []byte("lost"))
+	if _, err := l.active.Write(torn[:len(torn)-2]); err != nil {
+		t.Fatal(err)
+	}
+
+	l2 := openLog(t, dir, 0)
+	if got, want := replayed(t, l2), []string{"1:a", "2:b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replay = %v, want %v", got, want)
+	}
+	appendN(t, l2, "c")
+	if err := l2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l3 := openLog(t, dir, 0)
+	defer l3.Close()
+	if got, want := replayed(t, l3), []string{"1:a", "2:b", "3:c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replay after second reopen = %v, want %v", got, want)
+	}
+}
+
+func TestCheckpointOutOfOrderAcks(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, 0)
+	appendN(t, l, "a", "b", "c", "d", "e")
+
+	steps := []struct {
+		ack            []uint64
+		wantCheckpoint uint64
+		wantPending    uint64
+	}{
+		{[]uint64{3}, 0, 4},
+		{[]uint64{5, 2}, 0, 2},
+		{[]uint64{1}, 3, 1},
+		{[]uint64{2}, 3, 1}, // already behind the checkpoint
+		{[]uint64{4}, 5, 0},
+	}
+	for i, st := range steps {
+		if err := l.Ack(st.ack...); err != nil {
+			t.Fatalf("step %d: ack: %v", i, err)
+		}
+		if cp := l.Checkpoint(); cp != st.wantCheckpoint {
+			t.Fatalf("step %d: checkpoint = %d, want %d", i, cp, st.wantCheckpoint)
+		}
+		if p := l.Pending(); p != st.wantPending {
+			t.Fatalf("step %d: pending = %d, want %d", i, p, st.wantPending)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the checkpoint survives a reopen; only the gap that was never acked
+	// before a crash would be replayed
+	l = openLog(t, dir, 0)
+	defer l.Close()
+	if cp := l.Checkpoint(); cp != 5 {
+		t.Fatalf("checkpoint after reopen = %d, want 5", cp)
+	}
+	if got := replayed(t, l); len(got) != 0 {
+		t.Fatalf("replay = %v, want nothing", got)
+	}
+}
+
+func TestReplayAfterPartialAck(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, 0)
+	appendN(t, l, "a", "b", "c", "d")
+	if err := l.Ack(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, dir, 0)
+	defer l.Close()
+	// acks above the checkpoint are kept in memory only, so 3 comes back
+	if got, want := replayed(t, l), []string{"2:b", "3:c", "4:d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replay = %v, want %v", got, want)
+	}
+}
+
+func TestSegmentGC(t *testing.T) {
+	dir := t.TempDir()
+	// every append fills the active segment, so the next one rotates
+	l := openLog(t, dir, 1)
+	defer l.Close()
+	appendN(t, l, "a", "b", "c", "d")
+	if got := len(segmentFiles(t, dir)); got != 4 {
+		t.Fatalf("segments = %d, want 4", got)
+	}
+
+	if err := l.Ack(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(segmentFiles(t, dir)); got != 4 {
+		t.Fatalf("segments after acking past a gap = %d, want 4", got)
+	}
+
+	if err := l.Ack(1); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{fmt.Sprintf("%020d%s", 3, segmentExt), fmt.Sprintf("%020d%s", 4, segmentExt)}
+	if got := segmentFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("segments = %v, want %v", got, want)
+	}
+
+	// the active segment is never removed, even when fully acknowledged
+	if err := l.Ack(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{fmt.Sprintf("%020d%s", 4, segmentExt)}
+	if got := segmentFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("segments = %v, want %v", got, want)
+	}
+}
+
+func TestAppendBatchSequences(t *testing.T) {
+	l := openLog(t, t.TempDir(), 0)
+	defer l.Close()
+	seqs, err := l.AppendBatch([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 2, 3}; !reflect.DeepEqual(seqs, want) {
+		t.Fatalf("seqs = %v, want %v", seqs, want)
+	}
+	if _, err := l.Append(make([]byte, maxRecordBytes+1)); err == nil {
+		t.Fatal("oversized record accepted")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append([]byte("x")); err != ErrClosed {
+		t.Fatalf("append after close: err = %v, want ErrClosed", err)
+	}
+}
+
+// shortWriter writes only part of the next buffer and fails, like a full
+// disk; with noTruncate the segment cannot be cut back either.
+type shortWriter struct {
+	segmentFile
+	fail       bool
+	noTruncate bool
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if !w.fail {
+		return w.segmentFile.Write(p)
+	}
+	w.fail = false
+	n, _ := w.segmentFile.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func (w *shortWriter) Truncate(size int64) error {
+	if w.noTruncate {
+		return errors.New("read-only file system")
+	}
+	return w.segmentFile.Truncate(size)
+}
+
+func TestShortWriteDoesNotHideLaterRecords(t *testing.T) {
+	for _, noTruncate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("noTruncate=%t", noTruncate), func(t *testing.T) {
+			dir := t.TempDir()
+			l := openLog(t, dir, 0)
+			appendN(t, l, "a", "b")
+			l.active = &shortWriter{segmentFile: l.active, fail: true, noTruncate: noTruncate}
+			// the first failed record reaches the disk whole, the second torn
+			if _, err := l.AppendBatch([][]byte{[]byte("x"), []byte("lost-record")}); err == nil {
+				t.Fatal("short write reported success")
+			}
+			seqs := appendN(t, l, "c", "d")
+			if !noTruncate && !reflect.DeepEqual(seqs, []uint64{3, 4}) {
+				t.Fatalf("seqs after failed append = %v, want the failed ones reused", seqs)
+			}
+			if err := l.Ack(1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			l = openLog(t, dir, 0)
+			defer l.Close()
+			want := []string{fmt.Sprintf("%d:c", seqs[0]), fmt.Sprintf("%d:d", seqs[1])}
+			if got := replayed(t, l); !reflect.DeepEqual(got, want) {
+				t.Fatalf("replay = %v, want %v", got, want)
+			}
+			if seq := appendN(t, l, "e")[0]; seq != seqs[1]+1 {
+				t.Fatalf("next seq after reopen = %d, want %d", seq, seqs[1]+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+-- Reverts 0001_init: drops the events table (and its indexes).
+
+DROP TABLE IF EXISTS events;
@@ -0,0 +1,40 @@
+-- Reverts 0002_partition_events: back to a single unpartitioned events table.
+-- Unlike the up migration this copies every row, holding a lock on events
+-- until it commits; expect it to take as long as rewriting the table.
+
+CREATE TABLE events_plain (
+    id           BIGINT NOT NULL DEFAULT nextval('events_id_seq'),
+    event_id     TEXT NULL,
+    event_name   TEXT NOT NULL,
+    user_id      TEXT NOT NULL,
+    ts_epoch     BIGINT NOT NULL,
+    channel      TEXT NULL,
+    campaign_id  TEXT NULL,
+    tags         JSONB NULL,
+    metadata     JSONB NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT events_plain_pkey PRIMARY KEY (id)
+);
+
+INSERT INTO events_plain SELECT id, event_id, event_name, user_id, ts_epoch, channel, campaign_id, tags, metadata, created_at FROM events;
+
+ALTER SEQUENCE events_id_seq OWNED BY NONE;
+DROP TABLE events;                          -- drops every partition
+DROP TABLE event_id_keys;
+
+ALTER TABLE events_plain RENAME TO events;
+ALTER TABLE events RENAME CONSTRAINT events_plain_pkey TO events_pkey;
+ALTER SEQUENCE events_id_seq OWNED BY events.id;
+
+CREATE INDEX idx_events_event_name ON events (event_name);
+CREATE INDEX idx_events_ts_epoch   ON events (ts_epoch);
+CREATE INDEX idx_events_evname_ts  ON events (event_name, ts_epoch);
+CREATE INDEX idx_events_channel    ON events (channel);
+
+CREATE UNIQUE INDEX uq_events_event_id
+    ON events (event_id)
+    WHERE event_id IS NOT NULL;
+
+CREATE UNIQUE INDEX uq_events_composite
+    ON events (event_name, user_id, ts_epoch)
+    WHERE event_id IS NULL;
@@ -0,0 +1,87 @@
+-- Range-partition events on ts_epoch.
+--
+-- Partitions (events_pYYYYMM or events_pYYYYMMDD, UTC) are created at runtime
+-- by the partition manager (PARTITION_INTERVAL); rows without a matching
+-- partition land in events_default and are carved out into their own
+-- partition later, one period per transaction.
+--
+-- Existing rows are not copied: the old table itself becomes events_default,
+-- keeping its indexes, and the partition manager moves its rows out period by
+-- period once the service runs.
+--
+-- Unique indexes on a partitioned table must contain the partition key:
+--  * uq_events_composite already includes ts_epoch, so it stays global.
+--  * event_id uniqueness moves to the unpartitioned event_id_keys registry
+--    (its primary key is named uq_events_event_id); the writer claims a key
+--    there before inserting the event, in the same statement.
+--
+-- Expected downtime: the script is one transaction holding an ACCESS
+-- EXCLUSIVE lock on events from the first statement on, so reads and writes
+-- of events wait until it commits. Its cost grows with the table: two index
+-- builds over the existing rows (the (id, ts_epoch) primary key and
+-- (event_id, ts_epoch)) plus copying the rows that have an event_id into
+-- event_id_keys. On a large table run it in a maintenance window with
+-- `events-api migrate up` rather than through MIGRATE_ON_START.
+
+ALTER TABLE events RENAME TO events_default;
+ALTER SEQUENCE events_id_seq OWNED BY NONE;
+
+-- replaced by event_id_keys
+DROP INDEX IF EXISTS uq_events_event_id;
+
+-- free the names for the partitioned table's indexes; ATTACH adopts these
+-- as their events_default parts instead of building new ones
+ALTER INDEX idx_events_event_name RENAME TO events_default_event_name_idx;
+ALTER INDEX idx_events_ts_epoch   RENAME TO events_default_ts_epoch_idx;
+ALTER INDEX idx_events_evname_ts  RENAME TO events_default_event_name_ts_epoch_idx;
+ALTER INDEX idx_events_channel    RENAME TO events_default_channel_idx;
+ALTER INDEX uq_events_composite   RENAME TO events_default_event_name_user_id_ts_epoch_idx;
+
+-- the only two indexes built over the existing rows
+ALTER TABLE events_default DROP CONSTRAINT events_pkey;
+ALTER TABLE events_default ADD CONSTRAINT events_default_pkey PRIMARY KEY (id, ts_epoch);
+CREATE UNIQUE INDEX events_default_event_id_ts_epoch_idx
+    ON events_default (event_id, ts_epoch)
+    WHERE event_id IS NOT NULL;
+
+CREATE TABLE events (
+    id           BIGINT NOT NULL DEFAULT nextval('events_id_seq'),
+    event_id     TEXT NULL,
+    event_name   TEXT NOT NULL,
+    user_id      TEXT NOT NULL,
+    ts_epoch     BIGINT NOT NULL,           -- epoch seconds (UTC), partition key
+    channel      TEXT NULL,
+    campaign_id  TEXT NULL,
+    tags         JSONB NULL,
+    metadata     JSONB NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, ts_epoch)
+) PARTITION BY RANGE (ts_epoch);
+ALTER SEQUENCE events_id_seq OWNED BY events.id;
+
+-- still without partitions, so these only create the definitions
+CREATE INDEX idx_events_event_name ON events (event_name);
+CREATE INDEX idx_events_ts_epoch   ON events (ts_epoch);
+CREATE INDEX idx_events_evname_ts  ON events (event_name, ts_epoch);
+CREATE INDEX idx_events_channel    ON events (channel);
+
+CREATE UNIQUE INDEX uq_events_composite
+    ON events (event_name, user_id, ts_epoch)
+    WHERE event_id IS NULL;
+
+-- Per-partition backstop and lookup index; global uniqueness is event_id_keys.
+CREATE UNIQUE INDEX uq_events_event_id_ts
+    ON events (event_id, ts_epoch)
+    WHERE event_id IS NOT NULL;
+
+-- no other partition exists yet, so there is nothing to validate
+ALTER TABLE events ATTACH PARTITION events_default DEFAULT;
+
+CREATE TABLE event_id_keys (
+    event_id  TEXT NOT NULL,
+    ts_epoch  BIGINT NOT NULL,              -- locates the event's partition
+    CONSTRAINT uq_events_event_id PRIMARY KEY (event_id)
+);
+
+INSERT INTO event_id_keys (event_id, ts_epoch)
+SELECT event_id, ts_epoch FROM events_default WHERE event_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_event_id_keys_ts_epoch;
+DROP TABLE IF EXISTS retention_audit;
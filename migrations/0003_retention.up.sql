@@ -0,0 +1,13 @@
+-- Retention: audit trail of purges, and an index to purge event_id_keys by time.
+
+CREATE TABLE retention_audit (
+    id          BIGSERIAL PRIMARY KEY,
+    run_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rule        TEXT NOT NULL,              -- default | event_name=<v> | channel=<v> | all
+    action      TEXT NOT NULL,              -- delete | drop_partition
+    target      TEXT NOT NULL,              -- events or the dropped partition
+    cutoff      BIGINT NOT NULL,            -- rows with ts_epoch < cutoff were removed
+    rows        BIGINT NOT NULL
+);
+
+CREATE INDEX idx_event_id_keys_ts_epoch ON event_id_keys (ts_epoch);
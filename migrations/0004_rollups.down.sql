@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS events_rollup_users_daily;
+DROP TABLE IF EXISTS events_rollup_users_hourly;
+DROP TABLE IF EXISTS events_rollup_daily;
+DROP TABLE IF EXISTS events_rollup_hourly;
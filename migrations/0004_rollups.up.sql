@@ -0,0 +1,58 @@
+-- Hourly and daily rollups per (event_name, channel, campaign_id), maintained
+-- by the writer in the same statement that inserts the events.
+-- channel / campaign_id use '' for NULL so they can be part of the key.
+-- The *_users tables hold each bucket's distinct users, so unique counts over
+-- any set of aligned buckets stay exact without scanning events.
+
+CREATE TABLE events_rollup_hourly (
+    bucket_start  BIGINT NOT NULL,          -- epoch seconds, multiple of 3600
+    event_name    TEXT NOT NULL,
+    channel       TEXT NOT NULL DEFAULT '',
+    campaign_id   TEXT NOT NULL DEFAULT '',
+    count         BIGINT NOT NULL,
+    PRIMARY KEY (bucket_start, event_name, channel, campaign_id)
+);
+
+CREATE TABLE events_rollup_daily (
+    bucket_start  BIGINT NOT NULL,          -- epoch seconds, multiple of 86400 (UTC)
+    event_name    TEXT NOT NULL,
+    channel       TEXT NOT NULL DEFAULT '',
+    campaign_id   TEXT NOT NULL DEFAULT '',
+    count         BIGINT NOT NULL,
+    PRIMARY KEY (bucket_start, event_name, channel, campaign_id)
+);
+
+CREATE TABLE events_rollup_users_hourly (
+    bucket_start  BIGINT NOT NULL,
+    event_name    TEXT NOT NULL,
+    channel       TEXT NOT NULL DEFAULT '',
+    campaign_id   TEXT NOT NULL DEFAULT '',
+    user_id       TEXT NOT NULL,
+    PRIMARY KEY (bucket_start, event_name, channel, campaign_id, user_id)
+);
+
+CREATE TABLE events_rollup_users_daily (
+    bucket_start  BIGINT NOT NULL,
+    event_name    TEXT NOT NULL,
+    channel       TEXT NOT NULL DEFAULT '',
+    campaign_id   TEXT NOT NULL DEFAULT '',
+    user_id       TEXT NOT NULL,
+    PRIMARY KEY (bucket_start, event_name, channel, campaign_id, user_id)
+);
+
+-- Seed from existing events (later rebuilds: `events-api rollups backfill`).
+INSERT INTO events_rollup_hourly (bucket_start, event_name, channel, campaign_id, count)
+SELECT ts_epoch / 3600 * 3600, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), COUNT(*)
+FROM events GROUP BY 1, 2, 3, 4;
+
+INSERT INTO events_rollup_daily (bucket_start, event_name, channel, campaign_id, count)
+SELECT ts_epoch / 86400 * 86400, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), COUNT(*)
+FROM events GROUP BY 1, 2, 3, 4;
+
+INSERT INTO events_rollup_users_hourly (bucket_start, event_name, channel, campaign_id, user_id)
+SELECT DISTINCT ts_epoch / 3600 * 3600, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), user_id
+FROM events;
+
+INSERT INTO events_rollup_users_daily (bucket_start, event_name, channel, campaign_id, user_id)
+SELECT DISTINCT ts_epoch / 86400 * 86400, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), user_id
+FROM events;
@@ -0,0 +1,2 @@
+ALTER TABLE events_rollup_daily  DROP COLUMN IF EXISTS users_hll;
+ALTER TABLE events_rollup_hourly DROP COLUMN IF EXISTS users_hll;
@@ -0,0 +1,7 @@
+-- HyperLogLog sketch of each rollup bucket's users (internal/hll encoding).
+-- NULL means "not sketched yet": rows from before this migration until
+-- `events-api rollups backfill` rebuilds them; approximate queries fall back
+-- to exact counting when they meet one.
+
+ALTER TABLE events_rollup_hourly ADD COLUMN users_hll BYTEA NULL;
+ALTER TABLE events_rollup_daily  ADD COLUMN users_hll BYTEA NULL;
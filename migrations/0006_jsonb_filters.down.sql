@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_events_metadata_gin;
+DROP INDEX IF EXISTS idx_events_tags_gin;
@@ -0,0 +1,6 @@
+-- GIN indexes for /metrics filters on tags and metadata. jsonb_path_ops only
+-- serves containment (@>), which is how tag and equality filters are written;
+-- range comparisons on metadata read the rows the other conditions select.
+
+CREATE INDEX idx_events_tags_gin ON events USING GIN (tags jsonb_path_ops);
+CREATE INDEX idx_events_metadata_gin ON events USING GIN (metadata jsonb_path_ops);
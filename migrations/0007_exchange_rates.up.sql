@@ -0,0 +1,10 @@
+-- Daily exchange rates for revenue reports: the USD value of one unit of
+-- currency on a UTC day. USD itself is implied (1) and never stored.
+
+CREATE TABLE exchange_rates (
+    day           DATE NOT NULL,
+    currency      TEXT NOT NULL CHECK (currency ~ '^[A-Z]{3}$' AND currency <> 'USD'),
+    usd_per_unit  NUMERIC NOT NULL CHECK (usd_per_unit > 0),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (currency, day)
+);
@@ -0,0 +1,3 @@
+-- Reverts 0008_dead_letter.
+
+DROP TABLE IF EXISTS events_dead_letter;
@@ -0,0 +1,8 @@
+-- Events that permanently failed to insert (bad data, constraint violations).
+-- payload is TEXT on purpose: the poison is often JSON that JSONB rejects.
+CREATE TABLE IF NOT EXISTS events_dead_letter (
+    id          BIGSERIAL PRIMARY KEY,
+    payload     TEXT NOT NULL,
+    error       TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
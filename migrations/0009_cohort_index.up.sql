@@ -0,0 +1,5 @@
+-- Per-user lookup for /analytics/cohorts: whether a user has an earlier
+-- cohort event, and their return events. uq_events_composite covers the same
+-- columns but only for rows without an event_id.
+
+CREATE INDEX idx_events_evname_user_ts ON events (event_name, user_id, ts_epoch);
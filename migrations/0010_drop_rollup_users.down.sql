@@ -0,0 +1,27 @@
+-- Reverts 0010_drop_rollup_users: recreates the user lists from the events.
+
+CREATE TABLE events_rollup_users_hourly (
+    bucket_start  BIGINT NOT NULL,
+    event_name    TEXT NOT NULL,
+    channel       TEXT NOT NULL DEFAULT '',
+    campaign_id   TEXT NOT NULL DEFAULT '',
+    user_id       TEXT NOT NULL,
+    PRIMARY KEY (bucket_start, event_name, channel, campaign_id, user_id)
+);
+
+CREATE TABLE events_rollup_users_daily (
+    bucket_start  BIGINT NOT NULL,
+    event_name    TEXT NOT NULL,
+    channel       TEXT NOT NULL DEFAULT '',
+    campaign_id   TEXT NOT NULL DEFAULT '',
+    user_id       TEXT NOT NULL,
+    PRIMARY KEY (bucket_start, event_name, channel, campaign_id, user_id)
+);
+
+INSERT INTO events_rollup_users_hourly (bucket_start, event_name, channel, campaign_id, user_id)
+SELECT DISTINCT ts_epoch / 3600 * 3600, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), user_id
+FROM events;
+
+INSERT INTO events_rollup_users_daily (bucket_start, event_name, channel, campaign_id, user_id)
+SELECT DISTINCT ts_epoch / 86400 * 86400, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), user_id
+FROM events;
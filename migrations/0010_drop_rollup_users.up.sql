@@ -0,0 +1,6 @@
+-- The per-bucket user lists held one row per user, bucket and dimension, about
+-- as many rows as events. Unique users now come from the users_hll sketches
+-- (accuracy=approx) or from the raw events (exact); rollups keep only counts.
+
+DROP TABLE IF EXISTS events_rollup_users_hourly;
+DROP TABLE IF EXISTS events_rollup_users_daily;
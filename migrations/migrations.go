@@ -0,0 +1,14 @@
+// Package migrations embeds the versioned SQL migrations into the binary.
+//
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql and are
+// applied in version order. A migration whose first line is
+// "-- migrate:no-transaction" runs outside a transaction (e.g. for
+// CREATE INDEX CONCURRENTLY), one statement at a time, and may run again if
+// the process dies before it is recorded: every statement must use
+// IF [NOT] EXISTS, which loading the migrations enforces.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS